@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"protobuf/compare"
+	"protobuf/report"
+)
+
+// exitRegressed is the exit code of a comparison that found a regression
+const exitRegressed = 2
+
+// runCompare diffs a candidate run against a baseline run, both written with
+// -out, and fails when a metric regresses beyond its budget
+func runCompare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var budgetFlags stringList
+	flags.Var(&budgetFlags, "budget", `Allowed regression, e.g. "p95 +10%" or "rps -5%" (repeatable)`)
+	alpha := flags.Float64("alpha", 0.05, "Significance level a regression must reach")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: stress-test compare baseline.json candidate.json [-budget \"p95 +10%\"]...")
+		flags.PrintDefaults()
+	}
+
+	// Accept the results files before or after the flags
+	var files []string
+	for len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		files, args = append(files, args[0]), args[1:]
+	}
+	flags.Parse(args)
+	files = append(files, flags.Args()...)
+
+	if len(files) != 2 {
+		fmt.Println("Error: A baseline and a candidate results file are required")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		fmt.Println("Error: -alpha must be between 0 and 1")
+		os.Exit(1)
+	}
+
+	var budgets []compare.Budget
+	for _, b := range budgetFlags {
+		budget, err := compare.ParseBudget(b)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		budgets = append(budgets, budget)
+	}
+
+	baseline, err := report.Load(files[0])
+	if err != nil {
+		fmt.Printf("Error loading baseline: %v\n", err)
+		os.Exit(1)
+	}
+	candidate, err := report.Load(files[1])
+	if err != nil {
+		fmt.Printf("Error loading candidate: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Baseline:  %s (%s)\n", files[0], baseline.Started.Format("2006-01-02 15:04"))
+	fmt.Printf("Candidate: %s (%s)\n", files[1], candidate.Started.Format("2006-01-02 15:04"))
+
+	c := compare.Compare(baseline.Metrics, candidate.Metrics, budgets, *alpha)
+	printComparison(c)
+
+	regressions := c.Regressions()
+	if len(regressions) == 0 {
+		if len(budgets) > 0 {
+			fmt.Println("\nNo regressions beyond budget")
+		}
+		return
+	}
+
+	fmt.Println("\nRegressions:")
+	names := make([]string, 0, len(regressions))
+	for name := range regressions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, d := range regressions[name] {
+			fmt.Printf("FAIL  %s %s %s, budget %s\n", name, d.Metric, formatChange(d.Change), formatBudget(d))
+		}
+	}
+	os.Exit(exitRegressed)
+}
+
+// printComparison prints a table of metric changes per endpoint
+func printComparison(c *compare.Comparison) {
+	for _, e := range c.Endpoints {
+		fmt.Printf("\n%s:\n", e.Name)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "Metric\tBaseline\tCandidate\tChange\tp-value\tBudget\t\t")
+		for _, d := range e.Deltas {
+			verdict := ""
+			switch {
+			case d.Regressed:
+				verdict = "REGRESSED"
+			case !math.IsNaN(d.PValue) && d.PValue < c.Alpha && d.Change != 0:
+				verdict = "significant"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+				d.Metric, d.Format(d.Baseline), d.Format(d.Candidate), formatChange(d.Change),
+				formatPValue(d.PValue), formatBudget(d), verdict)
+		}
+		w.Flush()
+	}
+
+	for _, name := range c.OnlyBaseline {
+		fmt.Printf("\n%s: only in the baseline\n", name)
+	}
+	for _, name := range c.OnlyCandidate {
+		fmt.Printf("\n%s: only in the candidate\n", name)
+	}
+}
+
+// formatChange formats a relative change as a signed percentage
+func formatChange(change float64) string {
+	if math.IsInf(change, 0) {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*change)
+}
+
+// formatPValue formats a p-value, or a dash when the metric was not tested
+func formatPValue(p float64) string {
+	if math.IsNaN(p) {
+		return "-"
+	}
+	if p < 0.001 {
+		return "<0.001"
+	}
+	return fmt.Sprintf("%.3f", p)
+}
+
+// formatBudget formats the budget of a delta in the direction it applies,
+// or a dash when the metric has none
+func formatBudget(d compare.Delta) string {
+	if d.Budget == nil {
+		return "-"
+	}
+	if d.Metric == "rps" {
+		return fmt.Sprintf("-%g%%", 100*d.Budget.Change)
+	}
+	return fmt.Sprintf("+%g%%", 100*d.Budget.Change)
+}
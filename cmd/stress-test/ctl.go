@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"protobuf/control"
+)
+
+// runCtl changes a test running with -control-addr
+func runCtl(args []string) {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:9103", "Address the test serves its control API on")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: stress-test ctl [-addr localhost:9103] status|rate RPS|pause|resume|stage NAME|stop")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("Error: A command is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	client := control.NewClient(*addr)
+	command, operands := flags.Arg(0), flags.Args()[1:]
+	var status control.Status
+	var err error
+	switch {
+	case command == "status" && len(operands) == 0:
+		status, err = client.Status()
+	case command == "rate" && len(operands) == 1:
+		rps, convErr := strconv.Atoi(operands[0])
+		if convErr != nil {
+			fmt.Printf("Error: invalid rate %q\n", operands[0])
+			os.Exit(1)
+		}
+		status, err = client.SetRate(rps)
+	case command == "pause" && len(operands) == 0:
+		status, err = client.Pause()
+	case command == "resume" && len(operands) == 0:
+		status, err = client.Resume()
+	case command == "stage" && len(operands) > 0:
+		status, err = client.SetStage(strings.Join(operands, " "))
+	case command == "stop" && len(operands) == 0:
+		status, err = client.Stop()
+	default:
+		fmt.Printf("Error: unknown command %q\n", command)
+		flags.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printStatus(status)
+}
+
+// printStatus prints the state of a running test on one line
+func printStatus(s control.Status) {
+	state := "running"
+	switch {
+	case s.Stopping:
+		state = "stopping"
+	case s.Paused:
+		state = "paused"
+	}
+	fmt.Printf("%s, stage %s, %v elapsed: target %.0f RPS, achieved %.1f RPS, %d requests (%d failed), %d in flight\n",
+		state, s.Stage, s.Elapsed.Truncate(time.Second), s.TargetRPS, s.CurrentRPS, s.Requests, s.Failed, s.InFlight)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"protobuf/config"
+	"protobuf/distributed"
+	"protobuf/report"
+	"protobuf/threshold"
+)
+
+// runAgent serves jobs from a coordinator until interrupted
+func runAgent(args []string) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := flags.String("listen", ":9200", "Address to accept jobs from the coordinator on")
+	flags.Parse(args)
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Printf("Error starting agent: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Agent waiting for jobs on %s\n", listener.Addr())
+	if err := http.Serve(listener, distributed.NewAgent().Handler()); err != nil {
+		fmt.Printf("Error serving agent: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runCoordinator splits a test between agents and reports their merged results
+func runCoordinator(args []string) {
+	flags := flag.NewFlagSet("coordinator", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to configuration file")
+	duration := flags.Duration("duration", 5*time.Minute, "Test duration")
+	workers := flags.Int("workers", 100, "Number of concurrent workers, split between the agents")
+	var agents, outputs stringList
+	flags.Var(&agents, "agents", "Comma-separated agent addresses, e.g. host1:9200,host2:9200 (repeatable)")
+	flags.Var(&outputs, "out", "Write results to a .json, .csv or JUnit .xml file (repeatable)")
+	flags.Parse(args)
+
+	if *configFile == "" || len(agents) == 0 {
+		fmt.Println("Error: A configuration file and at least one agent are required")
+		flags.Usage()
+		os.Exit(1)
+	}
+	var addrs []string
+	for _, list := range agents {
+		for _, addr := range strings.Split(list, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.Duration = *duration
+
+	thresholds, err := threshold.New(cfg)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	configText, err := os.ReadFile(*configFile)
+	if err != nil {
+		fmt.Printf("Error reading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupts(cancel)
+
+	fmt.Printf("Starting distributed stress test across %d agents with %d workers for %v\n", len(addrs), *workers, cfg.Duration)
+	started := time.Now()
+	metrics, results, err := distributed.NewCoordinator(addrs).Run(ctx, cfg, *workers)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	printAgents(addrs, results)
+	printResults(metrics)
+	printSlowestTraced(metrics.SlowestTraced, cfg.Tracing.TraceURL)
+	checks := thresholds.Check(metrics)
+	printChecks(checks)
+
+	result := &report.Result{
+		Started:    started,
+		Duration:   cfg.Duration,
+		Workers:    *workers,
+		ConfigFile: *configFile,
+		Config:     string(configText),
+		TraceURL:   cfg.Tracing.TraceURL,
+		Metrics:    metrics,
+		Checks:     checks,
+	}
+	for _, path := range outputs {
+		if err := report.Write(path, result); err != nil {
+			fmt.Printf("Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Results written to %s\n", path)
+	}
+
+	if !result.Passed() {
+		os.Exit(exitThresholdsFailed)
+	}
+}
+
+// printAgents prints what each agent contributed
+func printAgents(addrs []string, results []*config.Metrics) {
+	fmt.Println("\nAgents:")
+	for i, m := range results {
+		fmt.Printf("%s: %d requests (%d failed, %d dropped), %.2f RPS\n",
+			addrs[i], m.TotalRequests, m.FailedRequests, m.DroppedRequests, m.AchievedRPS)
+	}
+}
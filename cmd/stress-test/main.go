@@ -4,22 +4,80 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"protobuf/config"
+	"protobuf/control"
+	"protobuf/dashboard"
+	"protobuf/prom"
+	"protobuf/report"
+	"protobuf/requestlog"
+	"protobuf/threshold"
+	"protobuf/tracing"
 	"protobuf/worker"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+// exitThresholdsFailed is the exit code of a run that breached a threshold
+const exitThresholdsFailed = 2
+
+// exitInterrupted is the exit code when a second interrupt forces an exit
+// before the requests in flight have drained
+const exitInterrupted = 130
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "compare":
+			runCompare(os.Args[2:])
+			return
+		case "ctl":
+			runCtl(os.Args[2:])
+			return
+		case "agent":
+			runAgent(os.Args[2:])
+			return
+		case "coordinator":
+			runCoordinator(os.Args[2:])
+			return
+		case "mock-server":
+			runMockServer(os.Args[2:])
+			return
+		}
+	}
+	runTest()
+}
+
+// runTest runs a single stress test for the configured duration
+func runTest() {
 	// Parse command line flags
 	configFile := flag.String("config", "", "Path to configuration file")
 	duration := flag.Duration("duration", 5*time.Minute, "Test duration")
-	workers := flag.Int("workers", 100, "Number of concurrent workers")
+	workers := flag.Int("workers", 100, "Number of concurrent workers (the minimum when concurrency.max is set)")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9102")
+	controlAddr := flag.String("control-addr", "", "Serve the control API on this address, e.g. localhost:9103")
+	var outputs stringList
+	flag.Var(&outputs, "out", "Write results to a .json, .csv or JUnit .xml file (repeatable)")
+	requestLogPath := flag.String("request-log", "", "Write a record of every request to a .jsonl or compact .bin file")
+	ui := flag.Bool("ui", false, "Show a live dashboard while the test runs (log lines when stdout is not a terminal)")
 	flag.Parse()
 
 	if *configFile == "" {
@@ -38,32 +96,251 @@ func main() {
 	// Override config with command line flags
 	cfg.Duration = *duration
 
+	thresholds, err := threshold.New(cfg)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Snapshot the configuration the results were produced with
+	configText, err := os.ReadFile(*configFile)
+	if err != nil {
+		fmt.Printf("Error reading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create worker pool
 	pool := worker.NewPool(*workers, cfg)
 
+	tracer, err := tracing.NewTracer(cfg.Tracing)
+	if err != nil {
+		fmt.Printf("Error starting tracing: %v\n", err)
+		os.Exit(1)
+	}
+	if tracer != nil {
+		pool.SetTracer(tracer)
+	}
+
+	if *metricsAddr != "" {
+		server, err := serveMetrics(*metricsAddr, pool)
+		if err != nil {
+			fmt.Printf("Error starting metrics server: %v\n", err)
+			os.Exit(1)
+		}
+		defer server.Close()
+	}
+
 	// Setup context with cancellation
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
+	// The warmup runs before the measured duration
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Warmup.Duration+cfg.Duration)
 	defer cancel()
 
 	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	handleInterrupts(cancel)
 
-	go func() {
-		<-sigChan
-		cancel()
-	}()
+	// The control API listens now, so a bad address fails before the test
+	// starts, but only serves once the pool has started
+	var controlListener net.Listener
+	if *controlAddr != "" {
+		controlListener, err = net.Listen("tcp", *controlAddr)
+		if err != nil {
+			fmt.Printf("Error starting control API: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// Start the stress test
-	fmt.Printf("Starting stress test with %d workers for %v\n", *workers, cfg.Duration)
+	if cfg.Model == "closed" {
+		fmt.Printf("Starting closed-model stress test with %d virtual users for %v\n", *workers, cfg.Duration)
+	} else {
+		fmt.Printf("Starting stress test with %d workers for %v\n", *workers, cfg.Duration)
+	}
+	if cfg.Warmup.Duration > 0 {
+		fmt.Printf("Warming up for %v first; warmup requests are excluded from the results\n", cfg.Warmup.Duration)
+	}
+	started := time.Now()
+
+	// Log every request, timed from the start of the run
+	var requestLog *requestlog.Writer
+	if *requestLogPath != "" {
+		names := make([]string, len(cfg.Endpoints))
+		for i, e := range cfg.Endpoints {
+			names[i] = e.DisplayName()
+		}
+		requestLog, err = requestlog.Create(*requestLogPath, requestlog.Header{
+			Started:         started,
+			Duration:        cfg.Duration,
+			Warmup:          cfg.Warmup.Duration,
+			Workers:         *workers,
+			ConfigFile:      *configFile,
+			Config:          string(configText),
+			Endpoints:       names,
+			LateThreshold:   cfg.LateThreshold,
+			MetricsInterval: cfg.MetricsInterval,
+		})
+		if err != nil {
+			fmt.Printf("Error creating request log: %v\n", err)
+			os.Exit(1)
+		}
+		pool.SetRequestLog(requestLog)
+	}
 	pool.Start(ctx)
+	if controlListener != nil {
+		server := serveControl(controlListener, pool, cancel)
+		defer server.Close()
+	}
+
+	// Abort early if a threshold marked abort fails
+	aborted := make(chan report.Check, 1)
+	go thresholds.Watch(ctx, pool.GetMetrics, func(check report.Check) {
+		aborted <- check
+		cancel()
+	})
+
+	uiDone := make(chan struct{})
+	if *ui {
+		d := dashboard.New(os.Stdout, dashboard.IsTerminal(os.Stdout), cfg.Duration)
+		go func() {
+			d.Run(ctx, pool.GetMetrics)
+			close(uiDone)
+		}()
+	} else {
+		close(uiDone)
+	}
 
 	// Wait for completion or interruption
 	<-ctx.Done()
+	<-uiDone
 
 	// Stop the pool and print results
 	pool.Stop()
-	printResults(pool.GetMetrics())
+	if err := tracer.Close(); err != nil {
+		fmt.Printf("Warning: closing trace exporter: %v\n", err)
+	}
+	if dropped := tracer.Dropped(); dropped > 0 {
+		fmt.Printf("Warning: %d spans were dropped because the exporter fell behind\n", dropped)
+	}
+	if err := requestLog.Close(); err != nil {
+		fmt.Printf("Warning: writing request log: %v\n", err)
+	}
+	if dropped := requestLog.Dropped(); dropped > 0 {
+		fmt.Printf("Warning: %d request log records were dropped because the disk fell behind\n", dropped)
+	}
+
+	metrics := pool.GetMetrics()
+	printResults(metrics)
+	printSlowestTraced(metrics.SlowestTraced, cfg.Tracing.TraceURL)
+
+	checks := thresholds.Check(metrics)
+	printChecks(checks)
+
+	failed := false
+	select {
+	case check := <-aborted:
+		fmt.Printf("\nTest aborted after %v: %s failed, %s\n", metrics.Elapsed.Truncate(time.Second), check.Name, check.Message)
+		failed = true
+	default:
+	}
+
+	result := &report.Result{
+		Started:    started,
+		Duration:   cfg.Duration,
+		Workers:    *workers,
+		ConfigFile: *configFile,
+		Config:     string(configText),
+		TraceURL:   cfg.Tracing.TraceURL,
+		Metrics:    metrics,
+		Checks:     checks,
+	}
+	for _, path := range outputs {
+		if err := report.Write(path, result); err != nil {
+			fmt.Printf("Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Results written to %s\n", path)
+	}
+
+	if failed || !result.Passed() {
+		os.Exit(exitThresholdsFailed)
+	}
+}
+
+// printChecks prints the outcome of each threshold
+func printChecks(checks []report.Check) {
+	if len(checks) == 0 {
+		return
+	}
+
+	fmt.Println("\nThresholds:")
+	for _, c := range checks {
+		status := "PASS"
+		if !c.Passed {
+			status = "FAIL"
+		}
+		fmt.Printf("%s  %s (%s)\n", status, c.Name, c.Message)
+	}
+}
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// printSlowestTraced lists the slowest sampled requests with their trace IDs,
+// or links to their traces if a trace URL is configured
+func printSlowestTraced(traced []config.TracedRequest, traceURL string) {
+	if len(traced) == 0 {
+		return
+	}
+
+	fmt.Println("\nSlowest Traced Requests:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range traced {
+		outcome := strconv.Itoa(r.Status)
+		if r.Error != "" {
+			outcome = r.Error
+		}
+		trace := r.TraceID
+		if traceURL != "" {
+			trace = strings.ReplaceAll(traceURL, "{trace_id}", r.TraceID)
+		}
+		fmt.Fprintf(w, "%v\t%s\t%s\t%s\n", r.ResponseTime, r.Endpoint, outcome, trace)
+	}
+	w.Flush()
+}
+
+// serveMetrics serves the pool's live metrics for Prometheus at /metrics
+func serveMetrics(addr string, pool *worker.Pool) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prom.Handler(pool.GetMetrics))
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	fmt.Printf("Serving Prometheus metrics on http://%s/metrics\n", listener.Addr())
+	return server, nil
+}
+
+// serveControl serves the control API of a started pool on listener; stopping
+// the test through it calls stop
+func serveControl(listener net.Listener, pool *worker.Pool, stop func()) *http.Server {
+	server := &http.Server{Handler: control.Handler(pool, stop)}
+	go server.Serve(listener)
+
+	fmt.Printf("Serving the control API on http://%s, change the test with: stress-test ctl -addr %s\n",
+		listener.Addr(), listener.Addr())
+	return server
 }
 
 func loadConfig(configFile string) (*config.Config, error) {
@@ -73,24 +350,170 @@ func loadConfig(configFile string) (*config.Config, error) {
 	}
 
 	var cfg config.Config
-	if err := viper.Unmarshal(&cfg); err != nil {
+	if err := viper.Unmarshal(&cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+		dc.DecodeHook = mapstructure.ComposeDecodeHookFunc(dc.DecodeHook, config.ThresholdHook, config.WarmupHook)
+	}); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if cfg.LoadPattern.Type == "curve" {
+		if err := cfg.LoadPattern.Curve.Load(filepath.Dir(configFile)); err != nil {
+			return nil, fmt.Errorf("error loading load curve: %w", err)
+		}
+	}
+
 	return &cfg, nil
 }
 
+// handleInterrupts ends the test on the first interrupt, letting the requests
+// in flight drain, and exits immediately on the second
+func handleInterrupts(cancel context.CancelFunc) {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Println("\nStopping, waiting for requests in flight to finish (interrupt again to exit now)")
+		cancel()
+		<-sigChan
+		fmt.Println("Interrupted again, exiting without results")
+		os.Exit(exitInterrupted)
+	}()
+}
+
 func printResults(metrics *config.Metrics) {
+	if w := metrics.Warmup; w != nil {
+		fmt.Printf("\nWarmup: %d requests (%d failed, %d dropped, %d cancelled) over %v, excluded from the results\n",
+			w.TotalRequests, w.FailedRequests, w.DroppedRequests, w.CancelledRequests, w.Elapsed.Round(time.Millisecond))
+	}
+
 	fmt.Println("\nTest Results:")
+	fmt.Printf("Scheduled Requests: %d\n", metrics.ScheduledRequests)
 	fmt.Printf("Total Requests: %d\n", metrics.TotalRequests)
 	fmt.Printf("Successful Requests: %d\n", metrics.SuccessfulRequests)
 	fmt.Printf("Failed Requests: %d\n", metrics.FailedRequests)
+	fmt.Printf("Dropped Requests: %d\n", metrics.DroppedRequests)
+	fmt.Printf("Cancelled Requests: %d (at shutdown)\n", metrics.CancelledRequests)
+	fmt.Printf("Late Requests: %d\n", metrics.LateRequests)
+	fmt.Printf("Peak Workers: %d\n", metrics.PeakWorkers)
 	fmt.Printf("Current RPS: %.2f\n", metrics.CurrentRPS)
-	fmt.Println("\nLatency Statistics:")
-	fmt.Printf("Min: %v\n", metrics.LatencyStats.Min)
-	fmt.Printf("Max: %v\n", metrics.LatencyStats.Max)
-	fmt.Printf("Mean: %v\n", metrics.LatencyStats.Mean)
-	fmt.Printf("P50: %v\n", metrics.LatencyStats.P50)
-	fmt.Printf("P95: %v\n", metrics.LatencyStats.P95)
-	fmt.Printf("P99: %v\n", metrics.LatencyStats.P99)
+	fmt.Printf("Average RPS: %.2f\n", metrics.AchievedRPS)
+	fmt.Println("\nService Time (from send):")
+	printLatencyStats(metrics.LatencyStats)
+	fmt.Println("\nResponse Time (from scheduled send):")
+	printLatencyStats(metrics.ResponseTimeStats)
+
+	if metrics.Phases != nil {
+		fmt.Println("\nPhases:")
+		printPhases(metrics.Phases)
+	}
+
+	if len(metrics.StatusCodes) > 0 {
+		fmt.Println("\nStatus Codes:")
+		printStatusCodes(metrics.StatusCodes, "")
+	}
+
+	if len(metrics.Errors) > 0 {
+		fmt.Println("\nErrors:")
+		printErrors(metrics.Errors, "")
+	}
+
+	printEndpoints(metrics.Endpoints)
+}
+
+// printEndpoints prints a table of per-endpoint metrics. Latencies are
+// response times, which include any wait for a free worker.
+func printEndpoints(endpoints []config.EndpointMetrics) {
+	fmt.Println("\nEndpoints:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Endpoint\tRequests\tFailed\tRPS\tMean\tP50\tP95\tP99\tMax\t")
+	for _, e := range endpoints {
+		s := e.ResponseTimeStats
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%v\t%v\t%v\t%v\t%v\t\n",
+			e.Name, e.TotalRequests, e.FailedRequests, e.RPS, s.Mean, s.P50, s.P95, s.P99, s.Max)
+	}
+	w.Flush()
+
+	for _, e := range endpoints {
+		if len(e.Errors) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s errors:\n", e.Name)
+		printErrors(e.Errors, "  ")
+	}
+}
+
+// printPhases prints a table of the time spent in each phase of a request.
+// Counts differ between phases because only new connections resolve, connect
+// and handshake.
+func printPhases(phases *config.PhaseStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Phase\tCount\tMean\tP50\tP95\tP99\tMax\t")
+	for _, phase := range []struct {
+		name  string
+		stats config.LatencyStats
+	}{
+		{"DNS", phases.DNS},
+		{"Connect", phases.Connect},
+		{"TLS", phases.TLS},
+		{"Write", phases.Write},
+		{"TTFB", phases.TTFB},
+		{"Transfer", phases.Transfer},
+	} {
+		s := phase.stats
+		fmt.Fprintf(w, "%s\t%d\t%v\t%v\t%v\t%v\t%v\t\n",
+			phase.name, s.Histogram.Count(), s.Mean, s.P50, s.P95, s.P99, s.Max)
+	}
+	w.Flush()
+}
+
+// printStatusCodes prints response counts in status code order
+func printStatusCodes(codes map[int]int64, indent string) {
+	statuses := make([]int, 0, len(codes))
+	for status := range codes {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Printf("%s%d: %d\n", indent, status, codes[status])
+	}
+}
+
+// printErrors prints failures by class, most frequent first, with their
+// sample messages
+func printErrors(errs map[string]config.ErrorStats, indent string) {
+	classes := make([]string, 0, len(errs))
+	for class := range errs {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if errs[classes[i]].Count != errs[classes[j]].Count {
+			return errs[classes[i]].Count > errs[classes[j]].Count
+		}
+		return classes[i] < classes[j]
+	})
+
+	for _, class := range classes {
+		fmt.Printf("%s%s: %d\n", indent, class, errs[class].Count)
+		for _, sample := range errs[class].Samples {
+			fmt.Printf("%s  e.g. %s\n", indent, sample)
+		}
+	}
+}
+
+func printLatencyStats(stats config.LatencyStats) {
+	fmt.Printf("Min: %v\n", stats.Min)
+	fmt.Printf("Max: %v\n", stats.Max)
+	fmt.Printf("Mean: %v\n", stats.Mean)
+	fmt.Printf("P50: %v\n", stats.P50)
+	fmt.Printf("P95: %v\n", stats.P95)
+	fmt.Printf("P99: %v\n", stats.P99)
+	fmt.Printf("P99.9: %v\n", stats.P999)
+	fmt.Printf("P99.99: %v\n", stats.P9999)
+	fmt.Printf("P99.999: %v\n", stats.P99999)
 }
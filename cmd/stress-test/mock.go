@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"protobuf/config"
+	"protobuf/mock"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// runMockServer serves mock routes to test against, for dry runs and demos
+func runMockServer(args []string) {
+	flags := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8080", "Address to serve the mock routes on")
+	routesFile := flags.String("config", "", "Path to a routes file (default: built-in demo routes)")
+	useTLS := flags.Bool("tls", false, "Serve over TLS with a self-signed certificate, which gRPC routes need for HTTP/2")
+	certFile := flags.String("cert", "", "TLS certificate file to use instead of a self-signed one")
+	keyFile := flags.String("key", "", "TLS key file for -cert")
+	flags.Parse(args)
+
+	cfg := mock.DefaultConfig()
+	if *routesFile != "" {
+		var err error
+		if cfg, err = loadMockConfig(*routesFile); err != nil {
+			fmt.Printf("Error loading routes: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	handler, err := mock.NewServer(cfg)
+	if err != nil {
+		fmt.Printf("Error loading routes: %v\n", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Handler: handler}
+	scheme := "http"
+	if *useTLS || *certFile != "" {
+		var cert tls.Certificate
+		if *certFile != "" {
+			cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+		} else {
+			cert, err = mock.SelfSignedCert()
+		}
+		if err != nil {
+			fmt.Printf("Error loading TLS certificate: %v\n", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		scheme = "https"
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Printf("Error starting mock server: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Mock server listening on %s://%s\n", scheme, listener.Addr())
+	printRoutes(cfg.Routes)
+
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil {
+		fmt.Printf("Error serving mock routes: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadMockConfig reads the routes of the mock server from a file
+func loadMockConfig(path string) (*mock.Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading routes file: %w", err)
+	}
+
+	var cfg mock.Config
+	if err := v.Unmarshal(&cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+	}); err != nil {
+		return nil, fmt.Errorf("error unmarshaling routes: %w", err)
+	}
+	return &cfg, nil
+}
+
+// printRoutes lists the routes served and how each answers
+func printRoutes(routes []mock.Route) {
+	fmt.Println("Routes:")
+	for _, r := range routes {
+		method := r.Method
+		if method == "" {
+			method = "*"
+		}
+		answer := "body"
+		if r.Echo != "" {
+			answer = r.Echo + " echo"
+		}
+		fmt.Printf("  %-6s %-16s %s, %s, %.0f%% errors\n", method, r.Path, answer, describeLatency(r.Latency), r.ErrorRate*100)
+	}
+}
+
+// describeLatency summarises a latency distribution
+func describeLatency(l config.ThinkTime) string {
+	switch l.Distribution {
+	case "uniform":
+		return fmt.Sprintf("%v-%v latency", l.Min, l.Max)
+	case "exponential":
+		return fmt.Sprintf("exponential latency with mean %v", l.Mean)
+	case "normal":
+		return fmt.Sprintf("%v±%v latency", l.Mean, l.StdDev)
+	default:
+		return fmt.Sprintf("%v latency", l.Mean)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"protobuf/report"
+	"protobuf/requestlog"
+	"protobuf/worker"
+)
+
+// runReport renders an HTML report from results written with -out, or from a
+// request log written with -request-log, whose aggregates are rebuilt first.
+// Rebuilt results can also be exported in any -out format.
+func runReport(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	output := flags.String("o", "report.html", "Path to write the HTML report to, or a .json, .csv or .xml results file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: stress-test report results.json|requests.jsonl|requests.bin [-o report.html]")
+		flags.PrintDefaults()
+	}
+
+	// Accept the input file before or after the flags
+	var input string
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		input, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if input == "" && flags.NArg() > 0 {
+		input = flags.Arg(0)
+	}
+
+	if input == "" {
+		fmt.Println("Error: A results file or request log is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var result *report.Result
+	var err error
+	switch strings.ToLower(filepath.Ext(input)) {
+	case ".jsonl", ".bin":
+		result, err = replayResult(input)
+	default:
+		result, err = report.Load(input)
+	}
+	if err != nil {
+		fmt.Printf("Error loading results: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !strings.EqualFold(filepath.Ext(*output), ".html") {
+		if err := report.Write(*output, result); err != nil {
+			fmt.Printf("Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Results written to %s\n", *output)
+		return
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Printf("Error creating report: %v\n", err)
+		os.Exit(1)
+	}
+	if err := report.WriteHTML(file, result); err != nil {
+		file.Close()
+		fmt.Printf("Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+	if err := file.Close(); err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Report written to %s\n", *output)
+}
+
+// replayResult rebuilds the results of a run from its request log
+func replayResult(path string) (*report.Result, error) {
+	log, err := requestlog.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	metrics, err := worker.Replay(log)
+	if err != nil {
+		return nil, err
+	}
+	h := log.Header
+	return &report.Result{
+		Started:    h.Started,
+		Duration:   h.Duration,
+		Workers:    h.Workers,
+		ConfigFile: h.ConfigFile,
+		Config:     h.Config,
+		Metrics:    metrics,
+	}, nil
+}
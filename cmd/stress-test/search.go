@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"protobuf/search"
+)
+
+// runSearch runs a capacity search to find the maximum sustainable RPS
+func runSearch(args []string) {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to configuration file")
+	workers := flags.Int("workers", 100, "Number of concurrent workers")
+	flags.Parse(args)
+
+	if *configFile == "" {
+		fmt.Println("Error: Configuration file is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handleInterrupts(cancel)
+
+	s := cfg.Search
+	fmt.Printf("Searching for maximum RPS between %d and %d, holding each rate for %v\n", s.MinRPS, s.MaxRPS, s.Hold)
+
+	runner := search.NewRunner(*workers, cfg)
+	runner.OnLevel = func(level search.Level) {
+		status := "PASS"
+		if !level.Passed {
+			status = "FAIL: " + level.Reason
+		}
+		fmt.Printf("  %6d RPS: achieved %.1f, p99 %v, errors %.2f%% - %s\n",
+			level.TargetRPS, level.AchievedRPS, level.P99, level.ErrorRate*100, status)
+	}
+
+	result, err := runner.Run(ctx)
+	if err != nil && result == nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Search interrupted: %v\n", err)
+	}
+	printSearchResult(result)
+}
+
+func printSearchResult(result *search.Result) {
+	fmt.Println("\nSearch Results:")
+	if result.MaxRPS == 0 {
+		fmt.Println("Maximum Sustainable RPS: none (the lowest rate did not meet the SLO)")
+	} else {
+		fmt.Printf("Maximum Sustainable RPS: %d\n", result.MaxRPS)
+	}
+
+	fmt.Println("\nLatency/Throughput Curve:")
+	fmt.Printf("%10s %12s %10s %10s %10s %8s  %s\n", "Target", "Achieved", "P50", "P95", "P99", "Errors", "Result")
+	for _, level := range result.Levels {
+		status := "pass"
+		if !level.Passed {
+			status = "fail: " + level.Reason
+		}
+		fmt.Printf("%10d %12.1f %10v %10v %10v %7.2f%%  %s\n",
+			level.TargetRPS, level.AchievedRPS, level.P50, level.P95, level.P99, level.ErrorRate*100, status)
+	}
+}
@@ -0,0 +1,335 @@
+// Package compare diffs the results of two test runs and flags regressions
+// that exceed a budget and are unlikely to be noise
+package compare
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+// Overall is the name of the comparison of the whole run, across endpoints
+const Overall = "all endpoints"
+
+// worseWhen maps each compared metric to the direction it regresses in: +1
+// when a higher value is worse, -1 when a lower one is
+var worseWhen = map[string]float64{
+	"p50":        1,
+	"p95":        1,
+	"p99":        1,
+	"p999":       1,
+	"max":        1,
+	"mean":       1,
+	"error_rate": 1,
+	"rps":        -1,
+}
+
+// metricOrder is the order metrics are compared and listed in
+var metricOrder = []string{"p50", "p95", "p99", "p999", "max", "mean", "error_rate", "rps"}
+
+// Budget is how much a metric may get worse before a change counts as a
+// regression
+type Budget struct {
+	Metric string
+	Change float64 // largest allowed relative change, e.g. 0.1 for 10%
+}
+
+// ParseBudget parses a budget of the form "<metric> <change>%", such as
+// "p95 +10%" or "rps -5%". The sign is optional but, when given, must be the
+// direction the metric regresses in.
+func ParseBudget(s string) (Budget, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Budget{}, fmt.Errorf("budget %q: expected <metric> <change>%%", s)
+	}
+	metric, value := fields[0], fields[1]
+
+	worse, ok := worseWhen[metric]
+	if !ok {
+		return Budget{}, fmt.Errorf("budget %q: unknown metric %s", s, metric)
+	}
+	if !strings.HasSuffix(value, "%") {
+		return Budget{}, fmt.Errorf("budget %q: change must be a percentage", s)
+	}
+	change, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return Budget{}, fmt.Errorf("budget %q: invalid percentage %s", s, value)
+	}
+	signed := strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	if signed && change*worse < 0 {
+		direction := "increases"
+		if worse < 0 {
+			direction = "decreases"
+		}
+		return Budget{}, fmt.Errorf("budget %q: %s regresses when it %s", s, metric, direction)
+	}
+	return Budget{Metric: metric, Change: math.Abs(change) / 100}, nil
+}
+
+// Delta is the change in one metric between the baseline and the candidate
+type Delta struct {
+	Metric    string
+	Baseline  float64 // nanoseconds for latencies
+	Candidate float64
+	Change    float64 // relative to the baseline, ±Inf when the baseline is zero
+	PValue    float64 // chance of a difference this large between identical runs; NaN when untested
+	Budget    *Budget // nil when the metric has no budget
+	Regressed bool    // worse than the budget allows, and significant
+}
+
+// Format formats a value of the delta's metric for display
+func (d Delta) Format(v float64) string {
+	switch d.Metric {
+	case "error_rate":
+		return fmt.Sprintf("%.2f%%", 100*v)
+	case "rps":
+		return fmt.Sprintf("%.2f", v)
+	default:
+		return time.Duration(v).Round(time.Microsecond).String()
+	}
+}
+
+// Endpoint is the comparison of one endpoint, or of the whole run
+type Endpoint struct {
+	Name   string
+	Deltas []Delta
+}
+
+// Comparison is the difference between two runs
+type Comparison struct {
+	Alpha         float64 // significance level changes are tested at
+	Endpoints     []Endpoint
+	OnlyBaseline  []string // endpoints the candidate did not run
+	OnlyCandidate []string // endpoints the baseline did not run
+}
+
+// Regressions returns every delta that regressed, by endpoint
+func (c *Comparison) Regressions() map[string][]Delta {
+	regressions := make(map[string][]Delta)
+	for _, e := range c.Endpoints {
+		for _, d := range e.Deltas {
+			if d.Regressed {
+				regressions[e.Name] = append(regressions[e.Name], d)
+			}
+		}
+	}
+	return regressions
+}
+
+// Compare diffs the candidate against the baseline, for the whole run and for
+// every endpoint both ran. A change is a regression when it is worse than its
+// budget and significant at level alpha, so noise between runs does not fail
+// a comparison.
+func Compare(baseline, candidate *config.Metrics, budgets []Budget, alpha float64) *Comparison {
+	c := &Comparison{Alpha: alpha}
+	c.Endpoints = append(c.Endpoints, compareRun(Overall,
+		sample{baseline.ResponseTimeStats, baseline.TotalRequests, baseline.FailedRequests, baseline.AchievedRPS, baseline.Elapsed},
+		sample{candidate.ResponseTimeStats, candidate.TotalRequests, candidate.FailedRequests, candidate.AchievedRPS, candidate.Elapsed},
+		budgets, alpha))
+
+	candidates := make(map[string]config.EndpointMetrics, len(candidate.Endpoints))
+	for _, e := range candidate.Endpoints {
+		candidates[e.Name] = e
+	}
+	seen := make(map[string]bool, len(baseline.Endpoints))
+	for _, b := range baseline.Endpoints {
+		seen[b.Name] = true
+		e, ok := candidates[b.Name]
+		if !ok {
+			c.OnlyBaseline = append(c.OnlyBaseline, b.Name)
+			continue
+		}
+		c.Endpoints = append(c.Endpoints, compareRun(b.Name,
+			sample{b.ResponseTimeStats, b.TotalRequests, b.FailedRequests, b.RPS, baseline.Elapsed},
+			sample{e.ResponseTimeStats, e.TotalRequests, e.FailedRequests, e.RPS, candidate.Elapsed},
+			budgets, alpha))
+	}
+	for _, e := range candidate.Endpoints {
+		if !seen[e.Name] {
+			c.OnlyCandidate = append(c.OnlyCandidate, e.Name)
+		}
+	}
+	return c
+}
+
+// sample is what one run recorded for an endpoint or for the whole run
+type sample struct {
+	stats    config.LatencyStats
+	requests int64
+	failed   int64
+	rps      float64
+	elapsed  time.Duration
+}
+
+// value returns a metric of the sample
+func (s sample) value(metric string) float64 {
+	switch metric {
+	case "p50":
+		return float64(s.stats.P50)
+	case "p95":
+		return float64(s.stats.P95)
+	case "p99":
+		return float64(s.stats.P99)
+	case "p999":
+		return float64(s.stats.P999)
+	case "max":
+		return float64(s.stats.Max)
+	case "mean":
+		return float64(s.stats.Mean)
+	case "error_rate":
+		if s.requests == 0 {
+			return 0
+		}
+		return float64(s.failed) / float64(s.requests)
+	default: // rps
+		return s.rps
+	}
+}
+
+// compareRun diffs every metric of two samples
+func compareRun(name string, baseline, candidate sample, budgets []Budget, alpha float64) Endpoint {
+	latencyP := ksTest(baseline.stats.Histogram, candidate.stats.Histogram)
+	errorP := proportionTest(baseline.failed, baseline.requests, candidate.failed, candidate.requests)
+	rpsP := rateTest(baseline.requests, baseline.elapsed, candidate.requests, candidate.elapsed)
+
+	e := Endpoint{Name: name}
+	for _, metric := range metricOrder {
+		d := Delta{
+			Metric:    metric,
+			Baseline:  baseline.value(metric),
+			Candidate: candidate.value(metric),
+			PValue:    latencyP,
+		}
+		switch metric {
+		case "error_rate":
+			d.PValue = errorP
+		case "rps":
+			d.PValue = rpsP
+		}
+		d.Change = relativeChange(d.Baseline, d.Candidate)
+
+		for i := range budgets {
+			if budgets[i].Metric == metric {
+				d.Budget = &budgets[i]
+			}
+		}
+		if d.Budget != nil {
+			significant := math.IsNaN(d.PValue) || d.PValue < alpha
+			d.Regressed = significant && d.Change*worseWhen[metric] > d.Budget.Change
+		}
+		e.Deltas = append(e.Deltas, d)
+	}
+	return e
+}
+
+// relativeChange returns the change from a to b as a fraction of a
+func relativeChange(a, b float64) float64 {
+	switch {
+	case a == b:
+		return 0
+	case a == 0:
+		return math.Inf(int(math.Copysign(1, b)))
+	default:
+		return b/a - 1
+	}
+}
+
+// ksTest runs a two-sample Kolmogorov-Smirnov test on two latency
+// histograms and returns the p-value, or NaN when either has no values. The
+// statistic is the largest gap between the cumulative distributions, which is
+// only measured at bin boundaries, so the test errs on the side of finding no
+// difference.
+func ksTest(a, b *metrics.Histogram) float64 {
+	if a == nil || b == nil || a.Count() == 0 || b.Count() == 0 {
+		return math.NaN()
+	}
+	binsA, binsB := a.Bins(), b.Bins()
+	na, nb := binTotal(binsA), binTotal(binsB)
+
+	var ca, cb, d float64
+	i, j := 0, 0
+	for i < len(binsA) || j < len(binsB) {
+		switch {
+		case j == len(binsB) || (i < len(binsA) && binsA[i].Value < binsB[j].Value):
+			ca += float64(binsA[i].Count)
+			i++
+		case i == len(binsA) || binsB[j].Value < binsA[i].Value:
+			cb += float64(binsB[j].Count)
+			j++
+		default:
+			ca += float64(binsA[i].Count)
+			cb += float64(binsB[j].Count)
+			i++
+			j++
+		}
+		d = math.Max(d, math.Abs(ca/na-cb/nb))
+	}
+
+	n := math.Sqrt(na * nb / (na + nb))
+	return ksProbability((n + 0.12 + 0.11/n) * d)
+}
+
+// binTotal returns the number of values in a set of bins
+func binTotal(bins []metrics.Bin) float64 {
+	var total float64
+	for _, bin := range bins {
+		total += float64(bin.Count)
+	}
+	return total
+}
+
+// ksProbability returns the Kolmogorov distribution's upper tail at lambda
+func ksProbability(lambda float64) float64 {
+	sum, sign := 0.0, 1.0
+	for k := 1; k <= 100; k++ {
+		term := sign * 2 * math.Exp(-2*float64(k*k)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-10 {
+			return math.Min(math.Max(sum, 0), 1)
+		}
+		sign = -sign
+	}
+	// The series only fails to converge for tiny gaps
+	return 1
+}
+
+// proportionTest runs a two-sided two-proportion z-test on two error rates
+// and returns the p-value, or NaN when either run has no requests
+func proportionTest(failedA, requestsA, failedB, requestsB int64) float64 {
+	if requestsA == 0 || requestsB == 0 {
+		return math.NaN()
+	}
+	na, nb := float64(requestsA), float64(requestsB)
+	pa, pb := float64(failedA)/na, float64(failedB)/nb
+	pooled := float64(failedA+failedB) / (na + nb)
+
+	se := math.Sqrt(pooled * (1 - pooled) * (1/na + 1/nb))
+	if se == 0 {
+		// Both runs had no errors, or nothing but errors
+		return 1
+	}
+	z := (pb - pa) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// rateTest runs a two-sided test on two request rates, treating the request
+// counts as Poisson, and returns the p-value, or NaN when either run has no
+// duration. Under an open workload the rate is set by the schedule, so this
+// mostly catches endpoints whose share of the traffic shifted by chance.
+func rateTest(requestsA int64, elapsedA time.Duration, requestsB int64, elapsedB time.Duration) float64 {
+	if elapsedA <= 0 || elapsedB <= 0 {
+		return math.NaN()
+	}
+	ta, tb := elapsedA.Seconds(), elapsedB.Seconds()
+	se := math.Sqrt(float64(requestsA)/(ta*ta) + float64(requestsB)/(tb*tb))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(requestsB)/tb - float64(requestsA)/ta) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
@@ -0,0 +1,130 @@
+package compare
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+// run builds the metrics of a run of n requests with latencies spread evenly
+// from base to twice base
+func run(n int, base time.Duration, failed int64) *config.Metrics {
+	h := metrics.NewHistogram(3)
+	for i := 0; i < n; i++ {
+		h.Record(base + base*time.Duration(i)/time.Duration(n))
+	}
+	stats := config.LatencyStats{
+		P50:       h.Quantile(0.50),
+		P95:       h.Quantile(0.95),
+		P99:       h.Quantile(0.99),
+		P999:      h.Quantile(0.999),
+		Max:       h.Max(),
+		Mean:      h.Mean(),
+		Histogram: h,
+	}
+	elapsed := 10 * time.Second
+	rps := float64(n) / elapsed.Seconds()
+	return &config.Metrics{
+		TotalRequests:     int64(n),
+		FailedRequests:    failed,
+		AchievedRPS:       rps,
+		Elapsed:           elapsed,
+		ResponseTimeStats: stats,
+		Endpoints: []config.EndpointMetrics{
+			{Name: "posts", TotalRequests: int64(n), FailedRequests: failed, RPS: rps, ResponseTimeStats: stats},
+		},
+	}
+}
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		input  string
+		budget Budget
+		ok     bool
+	}{
+		{"p95 +10%", Budget{Metric: "p95", Change: 0.1}, true},
+		{"p99 25%", Budget{Metric: "p99", Change: 0.25}, true},
+		{"rps -5%", Budget{Metric: "rps", Change: 0.05}, true},
+		{"error_rate +100%", Budget{Metric: "error_rate", Change: 1}, true},
+		{"p95 -10%", Budget{}, false},
+		{"rps +5%", Budget{}, false},
+		{"p42 +10%", Budget{}, false},
+		{"p95 +10", Budget{}, false},
+		{"p95+10%", Budget{}, false},
+	}
+
+	for _, tt := range tests {
+		budget, err := ParseBudget(tt.input)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseBudget(%q) error = %v, want ok=%v", tt.input, err, tt.ok)
+			continue
+		}
+		if tt.ok && (budget.Metric != tt.budget.Metric || math.Abs(budget.Change-tt.budget.Change) > 1e-9) {
+			t.Errorf("ParseBudget(%q) = %+v, want %+v", tt.input, budget, tt.budget)
+		}
+	}
+}
+
+func TestCompare_Regression(t *testing.T) {
+	budgets := []Budget{{Metric: "p95", Change: 0.1}, {Metric: "error_rate", Change: 0.5}}
+
+	// 20% slower and twice the errors over thousands of requests is real
+	c := Compare(run(5000, 10*time.Millisecond, 50), run(5000, 12*time.Millisecond, 100), budgets, 0.05)
+	regressions := c.Regressions()
+	for _, name := range []string{Overall, "posts"} {
+		var got []string
+		for _, d := range regressions[name] {
+			got = append(got, d.Metric)
+		}
+		if len(got) != 2 || got[0] != "p95" || got[1] != "error_rate" {
+			t.Errorf("%s: expected p95 and error_rate to regress, got %v", name, got)
+		}
+	}
+
+	// The same shift over a handful of requests could be noise
+	c = Compare(run(5, 10*time.Millisecond, 0), run(5, 12*time.Millisecond, 1), budgets, 0.05)
+	if r := c.Regressions(); len(r) != 0 {
+		t.Errorf("Expected no significant regressions over 5 requests, got %+v", r)
+	}
+	p95 := c.Endpoints[0].Deltas[1]
+	if p95.Metric != "p95" || p95.Change < 0.1 || p95.PValue < 0.05 {
+		t.Errorf("Expected an insignificant p95 change over budget, got %+v", p95)
+	}
+
+	// Within budget
+	c = Compare(run(5000, 10*time.Millisecond, 0), run(5000, 10500*time.Microsecond, 0), budgets, 0.05)
+	if r := c.Regressions(); len(r) != 0 {
+		t.Errorf("Expected no regressions within budget, got %+v", r)
+	}
+}
+
+func TestCompare_Identical(t *testing.T) {
+	m := run(1000, 10*time.Millisecond, 10)
+	c := Compare(m, m, []Budget{{Metric: "p99", Change: 0}}, 0.05)
+	for _, d := range c.Endpoints[0].Deltas {
+		if d.Change != 0 || d.PValue < 0.99 || d.Regressed {
+			t.Errorf("%s: expected no change between identical runs, got %+v", d.Metric, d)
+		}
+	}
+}
+
+func TestCompare_Endpoints(t *testing.T) {
+	baseline := run(100, 10*time.Millisecond, 0)
+	candidate := run(100, 10*time.Millisecond, 0)
+	baseline.Endpoints = append(baseline.Endpoints, config.EndpointMetrics{Name: "removed"})
+	candidate.Endpoints = append(candidate.Endpoints, config.EndpointMetrics{Name: "added"})
+
+	c := Compare(baseline, candidate, nil, 0.05)
+	if len(c.Endpoints) != 2 || c.Endpoints[1].Name != "posts" {
+		t.Errorf("Expected the overall run and posts to be compared, got %d endpoints", len(c.Endpoints))
+	}
+	if len(c.OnlyBaseline) != 1 || c.OnlyBaseline[0] != "removed" {
+		t.Errorf("Expected removed only in the baseline, got %v", c.OnlyBaseline)
+	}
+	if len(c.OnlyCandidate) != 1 || c.OnlyCandidate[0] != "added" {
+		t.Errorf("Expected added only in the candidate, got %v", c.OnlyCandidate)
+	}
+}
@@ -1,49 +1,609 @@
 package config
 
 import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
+
+	"protobuf/metrics"
 )
 
 // Config represents the main configuration structure
 type Config struct {
-	Endpoints   []Endpoint    `yaml:"endpoints"`
-	LoadPattern LoadPattern   `yaml:"load_pattern"`
-	Duration    time.Duration `yaml:"duration"`
-	MaxRPS      int           `yaml:"max_rps"`
+	Endpoints     []Endpoint    `yaml:"endpoints"`
+	LoadPattern   LoadPattern   `yaml:"load_pattern"`
+	Duration      time.Duration `yaml:"duration"`
+	MaxRPS        int           `yaml:"max_rps"`
+	Model         string        `yaml:"model"` // open (default), closed
+	ThinkTime     ThinkTime     `yaml:"think_time"`
+	LateThreshold time.Duration `yaml:"late_threshold"` // how far behind schedule a send may be before it is late
+	Search        Search        `yaml:"search"`
+	Concurrency   Concurrency   `yaml:"concurrency"`
+	Timeout       time.Duration `yaml:"timeout"` // per-request timeout, none if unset
+	// MetricsInterval is the width of each time series interval, 1s if unset
+	MetricsInterval time.Duration `yaml:"metrics_interval"`
+	Tracing         Tracing       `yaml:"tracing"`
+	Thresholds      []Threshold   `yaml:"thresholds"`
+	Warmup          Warmup        `yaml:"warmup"`
+	// PhaseTimings times the DNS, connect, TLS, write, first byte and
+	// transfer phases of every request. Requests are then sent with net/http,
+	// which is slower than the default client, so the tool's own overhead is
+	// higher.
+	PhaseTimings bool `yaml:"phase_timings"`
+	// DrainTimeout is how long requests in flight at the end of the test may
+	// take to finish before they are counted as cancelled, 10s if unset
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// Partition gives this process its own share of the test data, when
+	// several processes split a test between them
+	Partition Partition `yaml:"partition"`
+}
+
+// Partition is one of Count slices of the test data. CSV rows are dealt out
+// round robin, so partition i reads rows i, i+Count, i+2*Count and so on.
+type Partition struct {
+	Index int `yaml:"index"`
+	Count int `yaml:"count"` // no partitioning if unset
+}
+
+// Share returns the config for process index of count splitting the test
+// between them. Rates and worker bounds are divided as evenly as whole numbers
+// allow, so the shares add up to the original, and each process reads its
+// own partition of the test data. A limit below count leaves some shares at
+// 0, which means unlimited, so callers should reject such limits.
+func (c *Config) Share(index, count int) *Config {
+	s := *c
+	s.LoadPattern.StartRPS = Split(c.LoadPattern.StartRPS, index, count)
+	s.LoadPattern.Increment = Split(c.LoadPattern.Increment, index, count)
+	s.MaxRPS = Split(c.MaxRPS, index, count)
+	s.Warmup.RPS = Split(c.Warmup.RPS, index, count)
+	s.Concurrency.Min = Split(c.Concurrency.Min, index, count)
+	s.Concurrency.Max = Split(c.Concurrency.Max, index, count)
+
+	curve := &s.LoadPattern.Curve
+	curve.Points = make([]CurvePoint, len(c.LoadPattern.Curve.Points))
+	for i, point := range c.LoadPattern.Curve.Points {
+		curve.Points[i] = CurvePoint{Seconds: point.Seconds, RPS: point.RPS / float64(count)}
+	}
+	curve.File = "" // its points are already loaded
+	curve.Base /= float64(count)
+	curve.Amplitude /= float64(count)
+
+	s.Partition = Partition{Index: index, Count: count}
+	return &s
+}
+
+// Split returns the share of n given to process index of count, spreading
+// the remainder over the first processes
+func Split(n, index, count int) int {
+	share := n / count
+	if index < n%count {
+		share++
+	}
+	return share
+}
+
+// WarmupStage is the load stage of the warmup
+const WarmupStage = "warmup"
+
+// InitialStage returns the load stage the test starts in once any warmup is
+// over
+func (c *Config) InitialStage() string {
+	switch {
+	case c.Model == "closed":
+		return "closed"
+	case c.LoadPattern.Type == "ramp-up":
+		return RampUpStage(1)
+	case c.LoadPattern.Type == "":
+		return "constant"
+	default:
+		return c.LoadPattern.Type
+	}
+}
+
+// RampUpStage names a step of a ramp-up, counting from 1
+func RampUpStage(step int) string {
+	return fmt.Sprintf("ramp-up step %d", step)
+}
+
+// RampUpStep returns the step a ramp-up stage names, and false if stage does
+// not name one
+func RampUpStep(stage string) (int, bool) {
+	step, ok := strings.CutPrefix(stage, "ramp-up step ")
+	n, err := strconv.Atoi(step)
+	return n, ok && err == nil && n >= 1
+}
+
+// HasStage reports whether the load pattern can reach the named stage
+func (c *Config) HasStage(stage string) bool {
+	if stage == WarmupStage {
+		return c.Warmup.Duration > 0
+	}
+	if c.Model != "closed" && c.LoadPattern.Type == "ramp-up" {
+		_, ok := RampUpStep(stage)
+		return ok
+	}
+	return stage == c.InitialStage()
+}
+
+// Threshold is a limit the results must meet for the run to pass, such as
+// "p99 < 250ms", checked over the whole run, one endpoint or one load stage.
+// In a config file a threshold may be given as just its expression.
+type Threshold struct {
+	Expr       string        `yaml:"expr"`
+	Endpoint   string        `yaml:"endpoint"`    // endpoint name, the whole run if empty
+	Stage      string        `yaml:"stage"`       // load stage, e.g. "ramp-up step 3"
+	Abort      bool          `yaml:"abort"`       // stop the test as soon as the threshold fails
+	AbortAfter time.Duration `yaml:"abort_after"` // how long the test runs before it may abort, 10s if unset
+}
+
+// ThresholdHook lets a threshold be written as a bare expression string. It
+// is a mapstructure decode hook for loading the config.
+func ThresholdHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(Threshold{}) {
+		return data, nil
+	}
+	return Threshold{Expr: data.(string)}, nil
+}
+
+// Warmup sends requests before the measured part of the test, so connection
+// setup, JIT compilation and cold caches on the target do not skew the
+// results. Warmup requests are recorded separately. In a config file the
+// warmup may be given as just its duration.
+type Warmup struct {
+	Duration time.Duration `yaml:"duration"`
+	RPS      int           `yaml:"rps"` // rate during warmup, the load pattern's starting rate if unset
+}
+
+// WarmupHook lets a warmup be written as a bare duration. It is a
+// mapstructure decode hook for loading the config.
+func WarmupHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(Warmup{}) {
+		return data, nil
+	}
+	d, err := time.ParseDuration(data.(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid warmup: %w", err)
+	}
+	return Warmup{Duration: d}, nil
+}
+
+// Tracing configures W3C trace context propagation. Every request carries a
+// traceparent header; spans of the sampled ones are exported over OTLP to a
+// file or a collector.
+type Tracing struct {
+	Enabled     bool    `yaml:"enabled"`
+	SampleRatio float64 `yaml:"sample_ratio"` // fraction of requests sampled, 0 to 1
+	File        string  `yaml:"file"`         // OTLP/JSON lines file to write spans to
+	Endpoint    string  `yaml:"endpoint"`     // OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces
+	ServiceName string  `yaml:"service_name"` // defaults to stress-test
+	// TraceURL links a trace in the results, with {trace_id} replaced by
+	// the trace ID, e.g. http://localhost:16686/trace/{trace_id}
+	TraceURL string `yaml:"trace_url"`
+}
+
+// Validate checks the sampling ratio and exporter settings
+func (t *Tracing) Validate() error {
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("sample_ratio must be between 0 and 1")
+	}
+	if t.File != "" && t.Endpoint != "" {
+		return fmt.Errorf("set either file or endpoint, not both")
+	}
+	return nil
+}
+
+// Concurrency bounds the number of workers when the pool scales to sustain
+// the target rate. Without a max the worker count stays fixed.
+type Concurrency struct {
+	Min         int           `yaml:"min"` // defaults to the -workers flag
+	Max         int           `yaml:"max"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // how long an extra worker may idle before it exits
+}
+
+// Validate checks the settings that can be checked without I/O
+func (c *Config) Validate() error {
+	switch c.Model {
+	case "", "open":
+	case "closed":
+		if err := c.ThinkTime.Validate(); err != nil {
+			return fmt.Errorf("invalid think time: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown workload model: %s", c.Model)
+	}
+
+	if err := c.LoadPattern.Validate(); err != nil {
+		return fmt.Errorf("invalid load pattern: %w", err)
+	}
+	// A rate of 0 would pause the test for good. A capacity search sets its
+	// own rates, so it needs no start_rps.
+	if c.Model != "closed" && c.LoadPattern.Type != "curve" && c.LoadPattern.StartRPS < 1 && c.Search == (Search{}) {
+		return fmt.Errorf("invalid load pattern: start_rps must be at least 1")
+	}
+
+	if c.Warmup.Duration < 0 || c.Warmup.RPS < 0 {
+		return fmt.Errorf("warmup must not be negative")
+	}
+	if c.Warmup.RPS > 0 && c.Model == "closed" {
+		return fmt.Errorf("warmup rps does not apply to the closed model")
+	}
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("drain_timeout must not be negative")
+	}
+	if c.Partition.Count < 0 || c.Partition.Index < 0 || (c.Partition.Count > 0 && c.Partition.Index >= c.Partition.Count) {
+		return fmt.Errorf("partition index must be below its count")
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("invalid tracing: %w", err)
+	}
+
+	if c.Concurrency.Min < 0 || c.Concurrency.Max < 0 {
+		return fmt.Errorf("concurrency bounds must not be negative")
+	}
+	if c.Concurrency.Max > 0 && c.Concurrency.Max < c.Concurrency.Min {
+		return fmt.Errorf("concurrency max must not be below min")
+	}
+	return nil
 }
 
 // Endpoint represents a single API endpoint configuration
 type Endpoint struct {
+	Name        string            `yaml:"name"`
 	URL         string            `yaml:"url"`
 	Method      string            `yaml:"method"`
 	Headers     map[string]string `yaml:"headers"`
 	QueryParams map[string]string `yaml:"query_params"`
 	Body        interface{}       `yaml:"body"`
+	Assert      Assertions        `yaml:"assert"`
+}
+
+// Assertions are checks a response must pass to count as successful
+type Assertions struct {
+	Status       []int  `yaml:"status"` // accepted status codes, any 2xx if empty
+	BodyContains string `yaml:"body_contains"`
+}
+
+// DisplayName returns the name metrics are reported under, falling back to
+// the method and URL when the endpoint has no name
+func (e Endpoint) DisplayName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Method + " " + e.URL
 }
 
 // LoadPattern defines how the load should be applied
 type LoadPattern struct {
-	Type      string        `yaml:"type"` // constant, ramp-up, spike
+	Type      string        `yaml:"type"` // constant, ramp-up, spike, curve
 	StartRPS  int           `yaml:"start_rps"`
 	Increment int           `yaml:"increment"`
 	Interval  time.Duration `yaml:"interval"`
+	Curve     Curve         `yaml:"curve"`
+	Arrival   string        `yaml:"arrival"`    // constant (default), poisson, uniform, burst
+	BurstSize int           `yaml:"burst_size"` // requests per burst for burst arrivals
+}
+
+// Validate checks the load pattern settings that can be checked without I/O
+func (l *LoadPattern) Validate() error {
+	if l.Type == "ramp-up" && l.Interval <= 0 {
+		return fmt.Errorf("ramp-up requires a positive interval")
+	}
+	switch l.Arrival {
+	case "", "constant", "poisson", "uniform":
+	case "burst":
+		if l.BurstSize < 1 {
+			return fmt.Errorf("burst arrival requires a positive burst_size")
+		}
+	default:
+		return fmt.Errorf("unknown arrival distribution: %s", l.Arrival)
+	}
+	return nil
+}
+
+// Curve describes an arbitrary RPS-over-time profile for the curve load pattern.
+// The profile is either a list of points, read from a CSV file or given inline,
+// or a sine wave, the only function supported; other shapes can be given as
+// points.
+type Curve struct {
+	File      string        `yaml:"file"`      // CSV with t_seconds,rps rows, relative to the config file
+	Points    []CurvePoint  `yaml:"points"`    // inline alternative to file
+	Function  string        `yaml:"function"`  // sine, the only function
+	Base      float64       `yaml:"base"`      // mean RPS of the function
+	Amplitude float64       `yaml:"amplitude"` // peak deviation from base
+	Period    time.Duration `yaml:"period"`
+	Phase     time.Duration `yaml:"phase"`
+}
+
+// CurvePoint is a single target rate at an offset into the test
+type CurvePoint struct {
+	Seconds float64 `yaml:"t_seconds"`
+	RPS     float64 `yaml:"rps"`
+}
+
+// Load reads the curve file, if any, and validates the curve definition. A
+// relative file path is resolved against dir, the directory of the config
+// file.
+func (c *Curve) Load(dir string) error {
+	if c.File != "" {
+		path := c.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		points, err := readCurveFile(path)
+		if err != nil {
+			return err
+		}
+		c.Points = points
+	}
+
+	switch {
+	case len(c.Points) > 0:
+		for i := 1; i < len(c.Points); i++ {
+			if c.Points[i].Seconds < c.Points[i-1].Seconds {
+				return fmt.Errorf("curve points must be sorted by t_seconds (row %d)", i+1)
+			}
+		}
+	case c.Function == "sine":
+		if c.Period <= 0 {
+			return fmt.Errorf("sine curve requires a positive period")
+		}
+	case c.Function != "":
+		return fmt.Errorf("unknown curve function: %s", c.Function)
+	default:
+		return fmt.Errorf("curve requires a file, points or a function")
+	}
+	return nil
+}
+
+// readCurveFile parses a t_seconds,rps CSV file. A leading header row is skipped.
+func readCurveFile(path string) ([]CurvePoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening curve file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var points []CurvePoint
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading curve file: %w", err)
+		}
+
+		t, errT := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		rps, errR := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if errT != nil || errR != nil {
+			if row == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("invalid curve row %d: %v", row, record)
+		}
+		points = append(points, CurvePoint{Seconds: t, RPS: rps})
+	}
+
+	if len(points) == 0 {
+		return nil, fmt.Errorf("curve file %s has no data rows", path)
+	}
+	return points, nil
+}
+
+// ThinkTime describes the pause a virtual user takes between requests in the
+// closed workload model
+type ThinkTime struct {
+	Distribution string        `yaml:"distribution"` // fixed (default), uniform, exponential, normal
+	Mean         time.Duration `yaml:"mean"`         // fixed value, or mean for exponential and normal
+	Min          time.Duration `yaml:"min"`          // uniform lower bound
+	Max          time.Duration `yaml:"max"`          // uniform upper bound
+	StdDev       time.Duration `yaml:"stddev"`       // normal standard deviation
+}
+
+// Validate checks the think time distribution and its parameters
+func (t *ThinkTime) Validate() error {
+	switch t.Distribution {
+	case "", "fixed", "exponential":
+		if t.Mean < 0 {
+			return fmt.Errorf("mean must not be negative")
+		}
+	case "uniform":
+		if t.Min < 0 || t.Max < t.Min {
+			return fmt.Errorf("uniform think time requires 0 <= min <= max")
+		}
+	case "normal":
+		if t.Mean < 0 || t.StdDev < 0 {
+			return fmt.Errorf("mean and stddev must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown distribution: %s", t.Distribution)
+	}
+	return nil
+}
+
+// Draw returns a duration drawn from the distribution. Normal draws are
+// clamped at zero.
+func (t *ThinkTime) Draw(rng *rand.Rand) time.Duration {
+	switch t.Distribution {
+	case "uniform":
+		return t.Min + time.Duration(rng.Int63n(int64(t.Max-t.Min)+1))
+	case "exponential":
+		return time.Duration(rng.ExpFloat64() * float64(t.Mean))
+	case "normal":
+		d := time.Duration(float64(t.Mean) + rng.NormFloat64()*float64(t.StdDev))
+		if d < 0 {
+			return 0
+		}
+		return d
+	default:
+		return t.Mean
+	}
+}
+
+// Search configures the capacity search, which drives the target at increasing
+// rates to find the highest rate that still meets the SLO
+type Search struct {
+	Strategy string        `yaml:"strategy"` // binary (default), step
+	MinRPS   int           `yaml:"min_rps"`
+	MaxRPS   int           `yaml:"max_rps"`
+	Step     int           `yaml:"step"` // step-up increment, or the precision of a binary search
+	Hold     time.Duration `yaml:"hold"` // how long each rate is held
+	SLO      SLO           `yaml:"slo"`
+}
+
+// SLO is the service level a rate must meet to pass
+type SLO struct {
+	P99       time.Duration `yaml:"p99"`        // maximum p99 response time
+	ErrorRate float64       `yaml:"error_rate"` // maximum fraction of failed requests
+	// ThroughputTolerance is how far achieved RPS may fall short of the target
+	// as a fraction of it, so an overloaded client does not pass a rate it
+	// never actually sent. Defaults to 0.05.
+	ThroughputTolerance float64 `yaml:"throughput_tolerance"`
+}
+
+// Validate checks the search range and step
+func (s *Search) Validate() error {
+	switch s.Strategy {
+	case "", "binary", "step":
+	default:
+		return fmt.Errorf("unknown search strategy: %s", s.Strategy)
+	}
+	if s.MinRPS < 1 || s.MaxRPS < s.MinRPS {
+		return fmt.Errorf("search requires 1 <= min_rps <= max_rps")
+	}
+	if s.Step < 0 {
+		return fmt.Errorf("step must not be negative")
+	}
+	if s.Hold <= 0 {
+		return fmt.Errorf("search requires a positive hold duration")
+	}
+	if s.SLO.P99 <= 0 && s.SLO.ErrorRate <= 0 {
+		return fmt.Errorf("search requires a p99 or error_rate SLO")
+	}
+	return nil
 }
 
 // Metrics represents the collected metrics during the test
 type Metrics struct {
-	TotalRequests      int64
-	SuccessfulRequests int64
-	FailedRequests     int64
-	LatencyStats       LatencyStats
-	CurrentRPS         float64
+	TotalRequests      int64                 `json:"total_requests"`
+	SuccessfulRequests int64                 `json:"successful_requests"`
+	FailedRequests     int64                 `json:"failed_requests"`
+	ScheduledRequests  int64                 `json:"scheduled_requests"` // total, dropped and cancelled requests once the test has stopped
+	DroppedRequests    int64                 `json:"dropped_requests"`   // scheduled but never sent because every worker was busy
+	CancelledRequests  int64                 `json:"cancelled_requests"` // queued or in flight at shutdown and never completed
+	LateRequests       int64                 `json:"late_requests"`      // sent later than the late threshold after their scheduled time
+	ActiveWorkers      int64                 `json:"active_workers"`
+	PeakWorkers        int64                 `json:"peak_workers"`
+	InFlight           int64                 `json:"in_flight"`     // requests sent and awaiting a response
+	Paused             bool                  `json:"paused"`        // dispatch is paused through the control API
+	Stage              string                `json:"stage"`         // load stage the test is in, e.g. "ramp-up step 3"
+	LatencyStats       LatencyStats          `json:"service_time"`  // service time, from when the request was sent
+	ResponseTimeStats  LatencyStats          `json:"response_time"` // response time, from when the request was scheduled
+	TargetRPS          float64               `json:"target_rps"`    // rate the load pattern is asking for, 0 in the closed model
+	CurrentRPS         float64               `json:"current_rps"`   // achieved over the last completed interval
+	AchievedRPS        float64               `json:"achieved_rps"`  // average over the run
+	Elapsed            time.Duration         `json:"elapsed_ns"`
+	Errors             map[string]ErrorStats `json:"errors"`       // failed requests by error class
+	StatusCodes        map[int]int64         `json:"status_codes"` // responses by HTTP status code
+	Endpoints          []EndpointMetrics     `json:"endpoints"`
+	TimeSeries         []IntervalMetrics     `json:"time_series"`      // one entry per metrics interval, oldest first
+	SlowestTraced      []TracedRequest       `json:"slowest_traced"`   // slowest sampled requests, slowest first
+	Phases             *PhaseStats           `json:"phases,omitempty"` // nil unless phase timings are enabled
+	Warmup             *Metrics              `json:"warmup,omitempty"` // requests scheduled during warmup, nil without one
+}
+
+// PhaseStats breaks the service time of requests down by phase. DNS, connect
+// and TLS only happen when a request opens a new connection, so their
+// histograms count new connections rather than requests.
+type PhaseStats struct {
+	DNS      LatencyStats `json:"dns"`
+	Connect  LatencyStats `json:"connect"`
+	TLS      LatencyStats `json:"tls"`
+	Write    LatencyStats `json:"write"`    // from getting a connection to the request being written
+	TTFB     LatencyStats `json:"ttfb"`     // from the request being written to the first response byte
+	Transfer LatencyStats `json:"transfer"` // from the first response byte to the end of the body
+}
+
+// TracedRequest is a request whose span was sampled, so its trace can be
+// looked up in the tracing backend
+type TracedRequest struct {
+	Endpoint     string        `json:"endpoint"`
+	TraceID      string        `json:"trace_id"`
+	Start        time.Time     `json:"start"`
+	ResponseTime time.Duration `json:"response_time_ns"`
+	Status       int           `json:"status"` // HTTP status code, 0 if no response was received
+	Error        string        `json:"error"`  // error class of a failed request
+}
+
+// IntervalMetrics holds the metrics of a single time series interval.
+// Latencies are response times.
+type IntervalMetrics struct {
+	Start       time.Duration `json:"start_ns"` // offset of the interval from the start of the test
+	Duration    time.Duration `json:"duration_ns"`
+	Stage       string        `json:"stage"`      // load stage at the end of the interval
+	TargetRPS   float64       `json:"target_rps"` // rate the load pattern was asking for at the end of the interval, 0 in the closed model
+	AchievedRPS float64       `json:"achieved_rps"`
+	Requests    int64         `json:"requests"`
+	Failed      int64         `json:"failed"`
+	Dropped     int64         `json:"dropped"`
+	ErrorRate   float64       `json:"error_rate"` // fraction of requests that failed
+	P50         time.Duration `json:"p50_ns"`
+	P95         time.Duration `json:"p95_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	Max         time.Duration `json:"max_ns"`
+	Annotations []Annotation  `json:"annotations,omitempty"` // changes made to the running test during the interval
+	Partial     bool          `json:"partial,omitempty"`     // cut short by the end of the test
+}
+
+// Annotation records a change made to a running test, such as a new target
+// rate set through the control API
+type Annotation struct {
+	At   time.Duration `json:"at_ns"` // offset from the start of the test
+	Text string        `json:"text"`
+}
+
+// ErrorStats counts the failures of one error class
+type ErrorStats struct {
+	Count   int64    `json:"count"`
+	Samples []string `json:"samples"` // a few distinct error messages
+}
+
+// EndpointMetrics holds the metrics of a single endpoint
+type EndpointMetrics struct {
+	Name               string                `json:"name"`
+	TotalRequests      int64                 `json:"total_requests"`
+	SuccessfulRequests int64                 `json:"successful_requests"`
+	FailedRequests     int64                 `json:"failed_requests"`
+	Errors             map[string]ErrorStats `json:"errors"`       // failed requests by error class
+	StatusCodes        map[int]int64         `json:"status_codes"` // responses by HTTP status code
+	RPS                float64               `json:"rps"`
+	LatencyStats       LatencyStats          `json:"service_time"`
+	ResponseTimeStats  LatencyStats          `json:"response_time"`
+	Phases             *PhaseStats           `json:"phases,omitempty"`
 }
 
 // LatencyStats contains latency distribution statistics
 type LatencyStats struct {
-	Min  time.Duration
-	Max  time.Duration
-	Mean time.Duration
-	P50  time.Duration
-	P95  time.Duration
-	P99  time.Duration
+	Min       time.Duration      `json:"min_ns"`
+	Max       time.Duration      `json:"max_ns"`
+	Mean      time.Duration      `json:"mean_ns"`
+	P50       time.Duration      `json:"p50_ns"`
+	P95       time.Duration      `json:"p95_ns"`
+	P99       time.Duration      `json:"p99_ns"`
+	P999      time.Duration      `json:"p999_ns"`
+	P9999     time.Duration      `json:"p9999_ns"`
+	P99999    time.Duration      `json:"p99999_ns"`
+	Histogram *metrics.Histogram `json:"histogram"` // every recorded latency the stats were derived from
 }
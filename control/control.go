@@ -0,0 +1,208 @@
+// Package control serves a small HTTP API for changing a running test, and
+// is the client the ctl subcommand uses to call it
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"protobuf/config"
+)
+
+// Target is the running test the API controls
+type Target interface {
+	SetRate(rps int) error
+	Pause() error
+	Resume() error
+	SetStage(stage string) error
+	Annotate(text string)
+	GetMetrics() *config.Metrics
+}
+
+// Status is the state of a running test, returned by every operation
+type Status struct {
+	Stage      string        `json:"stage"`
+	Paused     bool          `json:"paused"`
+	TargetRPS  float64       `json:"target_rps"`
+	CurrentRPS float64       `json:"current_rps"`
+	Requests   int64         `json:"total_requests"`
+	Failed     int64         `json:"failed_requests"`
+	InFlight   int64         `json:"in_flight"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	Stopping   bool          `json:"stopping,omitempty"`
+}
+
+// Request is the body of the operations that take a value
+type Request struct {
+	RPS   int    `json:"rps,omitempty"`
+	Stage string `json:"stage,omitempty"`
+}
+
+// errorBody is the response to a failed operation
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the control API for t. GET /status reports on the test;
+// POST /rate, /pause, /resume, /stage and /stop change it. stop ends the
+// test gracefully, as an interrupt would.
+func Handler(t Target, stop func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeStatus(w, t, false)
+	})
+
+	operations := map[string]func(Request) error{
+		"/rate":   func(req Request) error { return t.SetRate(req.RPS) },
+		"/pause":  func(Request) error { return t.Pause() },
+		"/resume": func(Request) error { return t.Resume() },
+		"/stage":  func(req Request) error { return t.SetStage(req.Stage) },
+	}
+	for path, op := range operations {
+		op := op
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "use POST")
+				return
+			}
+			var req Request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+				return
+			}
+			if err := op(req); err != nil {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeStatus(w, t, false)
+		})
+	}
+
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		t.Annotate("stopped through the control API")
+		writeStatus(w, t, true)
+		stop()
+	})
+	return mux
+}
+
+// writeStatus responds with the current status of t
+func writeStatus(w http.ResponseWriter, t Target, stopping bool) {
+	m := t.GetMetrics()
+	writeJSON(w, http.StatusOK, Status{
+		Stage:      m.Stage,
+		Paused:     m.Paused,
+		TargetRPS:  m.TargetRPS,
+		CurrentRPS: m.CurrentRPS,
+		Requests:   m.TotalRequests,
+		Failed:     m.FailedRequests,
+		InFlight:   m.InFlight,
+		Elapsed:    m.Elapsed,
+		Stopping:   stopping,
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, errorBody{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client calls the control API of a running test
+type Client struct {
+	addr string
+	http *http.Client
+}
+
+// NewClient creates a client for the API served on addr, e.g. localhost:9103
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{addr: strings.TrimSuffix(addr, "/"), http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Status returns the state of the test
+func (c *Client) Status() (Status, error) {
+	return c.do(http.MethodGet, "/status", nil)
+}
+
+// SetRate sets the target rate
+func (c *Client) SetRate(rps int) (Status, error) {
+	return c.do(http.MethodPost, "/rate", &Request{RPS: rps})
+}
+
+// Pause stops sending new requests
+func (c *Client) Pause() (Status, error) {
+	return c.do(http.MethodPost, "/pause", nil)
+}
+
+// Resume continues a paused test
+func (c *Client) Resume() (Status, error) {
+	return c.do(http.MethodPost, "/resume", nil)
+}
+
+// SetStage moves the load pattern to the start of one of its stages
+func (c *Client) SetStage(stage string) (Status, error) {
+	return c.do(http.MethodPost, "/stage", &Request{Stage: stage})
+}
+
+// Stop ends the test gracefully
+func (c *Client) Stop() (Status, error) {
+	return c.do(http.MethodPost, "/stop", nil)
+}
+
+// do calls an operation and decodes the status or error it returns
+func (c *Client) do(method, path string, req *Request) (Status, error) {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return Status{}, err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return Status{}, err
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return Status{}, fmt.Errorf("error reaching the control API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorBody
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return Status{}, fmt.Errorf("control API returned %s", resp.Status)
+		}
+		return Status{}, fmt.Errorf("%s", e.Error)
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return Status{}, fmt.Errorf("invalid response from the control API: %w", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,126 @@
+package control
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"protobuf/config"
+)
+
+// fakeTarget records the operations called on it
+type fakeTarget struct {
+	rps         int
+	stage       string
+	paused      bool
+	annotations []string
+}
+
+func (f *fakeTarget) SetRate(rps int) error {
+	if rps < 1 {
+		return fmt.Errorf("rate must be at least 1 RPS")
+	}
+	f.rps = rps
+	return nil
+}
+
+func (f *fakeTarget) Pause() error {
+	if f.paused {
+		return fmt.Errorf("already paused")
+	}
+	f.paused = true
+	return nil
+}
+
+func (f *fakeTarget) Resume() error {
+	f.paused = false
+	return nil
+}
+
+func (f *fakeTarget) SetStage(stage string) error {
+	if !strings.HasPrefix(stage, "ramp-up step ") {
+		return fmt.Errorf("unknown stage %q", stage)
+	}
+	f.stage = stage
+	return nil
+}
+
+func (f *fakeTarget) Annotate(text string) {
+	f.annotations = append(f.annotations, text)
+}
+
+func (f *fakeTarget) GetMetrics() *config.Metrics {
+	return &config.Metrics{Stage: f.stage, Paused: f.paused, TargetRPS: float64(f.rps), TotalRequests: 42}
+}
+
+func TestClient_Operations(t *testing.T) {
+	target := &fakeTarget{stage: "ramp-up step 1", rps: 10}
+	stopped := false
+	server := httptest.NewServer(Handler(target, func() { stopped = true }))
+	defer server.Close()
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"))
+
+	status, err := client.SetRate(250)
+	if err != nil {
+		t.Fatalf("SetRate returned error: %v", err)
+	}
+	if target.rps != 250 || status.TargetRPS != 250 || status.Requests != 42 {
+		t.Errorf("Expected the rate to be set to 250 and reported, got %d and %+v", target.rps, status)
+	}
+
+	if status, err := client.Pause(); err != nil || !status.Paused {
+		t.Errorf("Expected a paused status, got %+v, %v", status, err)
+	}
+	if _, err := client.Pause(); err == nil || err.Error() != "already paused" {
+		t.Errorf("Expected the target's error to be passed back, got %v", err)
+	}
+	if status, err := client.Resume(); err != nil || status.Paused {
+		t.Errorf("Expected a running status, got %+v, %v", status, err)
+	}
+
+	if status, err := client.SetStage("ramp-up step 3"); err != nil || status.Stage != "ramp-up step 3" {
+		t.Errorf("Expected the stage to change, got %+v, %v", status, err)
+	}
+	if _, err := client.SetStage("after-deploy"); err == nil || err.Error() != `unknown stage "after-deploy"` {
+		t.Errorf("Expected an unknown stage to be rejected, got %v", err)
+	}
+
+	if status, err := client.Stop(); err != nil || !status.Stopping {
+		t.Errorf("Expected a stopping status, got %+v, %v", status, err)
+	}
+	if !stopped || len(target.annotations) != 1 {
+		t.Errorf("Expected stop to be called and annotated, got %v and %v", stopped, target.annotations)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	server := httptest.NewServer(Handler(&fakeTarget{}, func() {}))
+	defer server.Close()
+
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/rate", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/status", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/rate", "{", http.StatusBadRequest},
+		{http.MethodPost, "/rate", `{"rps":0}`, http.StatusConflict},
+		{http.MethodPost, "/unknown", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s %s %s: expected status %d, got %d", tt.method, tt.path, tt.body, tt.code, resp.StatusCode)
+		}
+	}
+}
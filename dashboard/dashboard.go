@@ -0,0 +1,193 @@
+// Package dashboard shows the progress of a running test
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"protobuf/config"
+)
+
+// refreshInterval is how often the dashboard is redrawn
+const refreshInterval = time.Second
+
+// progressWidth is the number of characters in the progress bar
+const progressWidth = 30
+
+// clearScreen moves the cursor to the top left and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// Dashboard periodically renders the metrics of a running test. On a terminal
+// it redraws a full-screen view in place; anywhere else it writes one log
+// line per refresh, so output redirected to a file stays readable.
+type Dashboard struct {
+	out      io.Writer
+	live     bool
+	duration time.Duration // planned test duration, 0 if unknown
+}
+
+// New creates a dashboard writing to out. With live set the view is redrawn
+// in place, which requires out to be a terminal.
+func New(out io.Writer, live bool, duration time.Duration) *Dashboard {
+	return &Dashboard{
+		out:      out,
+		live:     live,
+		duration: duration,
+	}
+}
+
+// IsTerminal reports whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Run renders the metrics returned by source every second until ctx is done.
+// Frames are drawn half way between the pool's time series intervals, so each
+// one shows an interval that has just closed.
+func (d *Dashboard) Run(ctx context.Context, source func() *config.Metrics) {
+	timer := time.NewTimer(refreshInterval + refreshInterval/2)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			d.Render(source())
+			timer.Reset(refreshInterval)
+		}
+	}
+}
+
+// Render draws a single frame, or writes a single log line
+func (d *Dashboard) Render(m *config.Metrics) {
+	if d.live {
+		d.renderScreen(m)
+	} else {
+		d.renderLine(m)
+	}
+}
+
+// renderLine writes a one-line summary of the last interval
+func (d *Dashboard) renderLine(m *config.Metrics) {
+	last := lastInterval(m)
+	fmt.Fprintf(d.out, "[%s] stage=%s target=%.0f rps achieved=%.1f rps in_flight=%d requests=%d errors=%.2f%% p50=%v p99=%v\n",
+		d.elapsed(m), m.Stage, m.TargetRPS, m.CurrentRPS, m.InFlight,
+		m.TotalRequests, 100*errorRate(m), round(last.P50), round(last.P99))
+}
+
+// renderScreen redraws the full-screen view
+func (d *Dashboard) renderScreen(m *config.Metrics) {
+	var buf bytes.Buffer
+	buf.WriteString(clearScreen)
+
+	fmt.Fprintf(&buf, "%s  %s  stage: %s", d.progressBar(m), d.elapsed(m), m.Stage)
+	if m.Paused {
+		buf.WriteString("  PAUSED")
+	}
+	buf.WriteString("\n\n")
+
+	last := lastInterval(m)
+	w := tabwriter.NewWriter(&buf, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "Target RPS\t%.0f\tRequests\t%d\tWorkers\t%d (peak %d)\n",
+		m.TargetRPS, m.TotalRequests, m.ActiveWorkers, m.PeakWorkers)
+	fmt.Fprintf(w, "Achieved RPS\t%.1f\tFailed\t%d (%.2f%%)\tIn flight\t%d\n",
+		m.CurrentRPS, m.FailedRequests, 100*errorRate(m), m.InFlight)
+	fmt.Fprintf(w, "Average RPS\t%.1f\tDropped\t%d\tLate\t%d\n",
+		m.AchievedRPS, m.DroppedRequests, m.LateRequests)
+	w.Flush()
+
+	fmt.Fprintf(&buf, "\nResponse time (last %v): p50 %v  p95 %v  p99 %v  max %v\n",
+		last.Duration.Round(100*time.Millisecond), round(last.P50), round(last.P95), round(last.P99), round(last.Max))
+
+	if len(m.Errors) > 0 {
+		buf.WriteString("\nErrors:\n")
+		w = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		for _, class := range errorClasses(m.Errors) {
+			count := m.Errors[class].Count
+			fmt.Fprintf(w, "  %s\t%d\t%.2f%%\n", class, count, 100*float64(count)/float64(m.TotalRequests))
+		}
+		w.Flush()
+	}
+
+	if len(m.Endpoints) > 0 {
+		buf.WriteString("\nEndpoints:\n")
+		w = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "Endpoint\tRequests\tFailed\tRPS\tP50\tP99\t")
+		for _, e := range m.Endpoints {
+			s := e.ResponseTimeStats
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%v\t%v\t\n",
+				e.Name, e.TotalRequests, e.FailedRequests, e.RPS, round(s.P50), round(s.P99))
+		}
+		w.Flush()
+	}
+
+	d.out.Write(buf.Bytes())
+}
+
+// elapsed formats the time into the test, with the planned duration if known
+func (d *Dashboard) elapsed(m *config.Metrics) string {
+	elapsed := m.Elapsed.Truncate(time.Second)
+	if d.duration <= 0 {
+		return elapsed.String()
+	}
+	return fmt.Sprintf("%v / %v", elapsed, d.duration)
+}
+
+// progressBar draws the fraction of the planned duration that has passed
+func (d *Dashboard) progressBar(m *config.Metrics) string {
+	if d.duration <= 0 {
+		return ""
+	}
+	fraction := float64(m.Elapsed) / float64(d.duration)
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * progressWidth)
+	return fmt.Sprintf("[%s%s] %3.0f%%",
+		strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled), 100*fraction)
+}
+
+// round trims a latency to microseconds for display
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+// lastInterval returns the most recent time series interval
+func lastInterval(m *config.Metrics) config.IntervalMetrics {
+	if len(m.TimeSeries) == 0 {
+		return config.IntervalMetrics{}
+	}
+	return m.TimeSeries[len(m.TimeSeries)-1]
+}
+
+// errorRate returns the fraction of all requests that failed
+func errorRate(m *config.Metrics) float64 {
+	if m.TotalRequests == 0 {
+		return 0
+	}
+	return float64(m.FailedRequests) / float64(m.TotalRequests)
+}
+
+// errorClasses returns the error classes, most frequent first
+func errorClasses(errs map[string]config.ErrorStats) []string {
+	classes := make([]string, 0, len(errs))
+	for class := range errs {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if errs[classes[i]].Count != errs[classes[j]].Count {
+			return errs[classes[i]].Count > errs[classes[j]].Count
+		}
+		return classes[i] < classes[j]
+	})
+	return classes
+}
@@ -0,0 +1,63 @@
+package dashboard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+func testMetrics() *config.Metrics {
+	return &config.Metrics{
+		TotalRequests:  200,
+		FailedRequests: 10,
+		InFlight:       3,
+		Stage:          "ramp-up step 2",
+		TargetRPS:      100,
+		CurrentRPS:     98.5,
+		Elapsed:        30 * time.Second,
+		Errors: map[string]config.ErrorStats{
+			"timeout":     {Count: 8},
+			"http_status": {Count: 2},
+		},
+		Endpoints: []config.EndpointMetrics{{Name: "users", TotalRequests: 200}},
+		TimeSeries: []config.IntervalMetrics{
+			{Duration: time.Second, P50: 5 * time.Millisecond, P99: 40 * time.Millisecond},
+		},
+	}
+}
+
+func TestDashboard_LogLine(t *testing.T) {
+	var out bytes.Buffer
+	New(&out, false, time.Minute).Render(testMetrics())
+
+	want := "[30s / 1m0s] stage=ramp-up step 2 target=100 rps achieved=98.5 rps in_flight=3 requests=200 errors=5.00% p50=5ms p99=40ms\n"
+	if out.String() != want {
+		t.Errorf("Render() wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestDashboard_Screen(t *testing.T) {
+	var out bytes.Buffer
+	New(&out, true, time.Minute).Render(testMetrics())
+
+	screen := out.String()
+	if !strings.HasPrefix(screen, clearScreen) {
+		t.Error("Expected the screen to be cleared before redrawing")
+	}
+	for _, want := range []string{
+		"[###############---------------]  50%",
+		"stage: ramp-up step 2",
+		"p50 5ms",
+		"users",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("Expected screen to contain %q:\n%s", want, screen)
+		}
+	}
+	if strings.Index(screen, "timeout") > strings.Index(screen, "http_status") {
+		t.Error("Expected the most frequent error class first")
+	}
+}
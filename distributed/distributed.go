@@ -0,0 +1,342 @@
+// Package distributed runs a test across agents on several machines, for load
+// beyond what one machine can send. A coordinator splits the target rate,
+// workers and test data between the agents, starts them together and merges
+// their results into one.
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"protobuf/config"
+	"protobuf/tracing"
+	"protobuf/worker"
+)
+
+// startDelay is how far ahead the coordinator schedules the start, so every
+// agent has its job before the test begins
+const startDelay = 2 * time.Second
+
+// maxStartSkew is how late an agent may receive its job and still start. A
+// job that arrives later points at clocks out of sync.
+const maxStartSkew = time.Second
+
+// requestTimeout bounds the status and stop calls to an agent
+const requestTimeout = 10 * time.Second
+
+// Job is an agent's share of a test
+type Job struct {
+	Config  *config.Config `json:"config"` // already split, with the agent's partition set
+	Workers int            `json:"workers"`
+	Start   time.Time      `json:"start"` // when to start, so every agent starts together
+}
+
+// AgentStatus reports whether an agent is running a test
+type AgentStatus struct {
+	Running bool `json:"running"`
+}
+
+// errorBody is the response to a failed call
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// Agent runs the jobs a coordinator sends it, one at a time
+type Agent struct {
+	mu   sync.Mutex
+	stop context.CancelFunc // stops the running test, nil when idle
+}
+
+// NewAgent creates an idle agent
+func NewAgent() *Agent {
+	return &Agent{}
+}
+
+// Handler returns the agent's API. POST /run runs a job and responds with its
+// metrics once it has finished; POST /stop ends the running test early, and
+// GET /status reports whether one is running. A coordinator that disconnects
+// from /run stops the test.
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		a.mu.Lock()
+		running := a.stop != nil
+		a.mu.Unlock()
+		writeJSON(w, http.StatusOK, AgentStatus{Running: running})
+	})
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		var job Job
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: %v", err))
+			return
+		}
+		m, code, err := a.run(r.Context(), &job)
+		if err != nil {
+			writeError(w, code, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		a.mu.Lock()
+		stop := a.stop
+		a.mu.Unlock()
+		if stop == nil {
+			writeError(w, http.StatusConflict, "no test is running")
+			return
+		}
+		stop()
+		writeJSON(w, http.StatusOK, AgentStatus{Running: true})
+	})
+	return mux
+}
+
+// run waits for the job's start time, runs the test and returns its metrics,
+// or an error with the HTTP status code to respond with
+func (a *Agent) run(ctx context.Context, job *Job) (*config.Metrics, int, error) {
+	cfg := job.Config
+	if cfg == nil || len(cfg.Endpoints) == 0 || job.Workers < 1 {
+		return nil, http.StatusBadRequest, fmt.Errorf("job needs a config with endpoints and at least one worker")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid config: %w", err)
+	}
+	if late := time.Since(job.Start); late > maxStartSkew {
+		return nil, http.StatusBadRequest, fmt.Errorf("start time passed %v ago, check the clocks are in sync", late.Round(time.Millisecond))
+	}
+
+	a.mu.Lock()
+	if a.stop != nil {
+		a.mu.Unlock()
+		return nil, http.StatusConflict, fmt.Errorf("agent is already running a test")
+	}
+	ctx, stop := context.WithCancel(ctx)
+	a.stop = stop
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.stop = nil
+		a.mu.Unlock()
+		stop()
+	}()
+
+	pool := worker.NewPool(job.Workers, cfg)
+	tracer, err := tracing.NewTracer(cfg.Tracing)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("error starting tracing: %w", err)
+	}
+	if tracer != nil {
+		pool.SetTracer(tracer)
+	}
+	defer tracer.Close()
+
+	fmt.Printf("Running partition %d of %d with %d workers for %v, starting at %s\n",
+		cfg.Partition.Index+1, cfg.Partition.Count, job.Workers, cfg.Duration, job.Start.Format(time.RFC3339Nano))
+	timer := time.NewTimer(time.Until(job.Start))
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return nil, http.StatusConflict, fmt.Errorf("stopped before the start time")
+	case <-timer.C:
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Warmup.Duration+cfg.Duration)
+	defer cancel()
+	pool.Start(runCtx)
+	<-runCtx.Done()
+	pool.Stop()
+
+	m := pool.GetMetrics()
+	fmt.Printf("Finished: %d requests (%d failed) in %v\n", m.TotalRequests, m.FailedRequests, m.Elapsed.Round(time.Millisecond))
+	return m, http.StatusOK, nil
+}
+
+// Coordinator runs a test across a set of agents
+type Coordinator struct {
+	agents []string // base URLs
+	client *http.Client
+}
+
+// NewCoordinator creates a coordinator for the agents at addrs, e.g.
+// host1:9200
+func NewCoordinator(addrs []string) *Coordinator {
+	agents := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		agents[i] = strings.TrimSuffix(addr, "/")
+	}
+	return &Coordinator{agents: agents, client: &http.Client{}}
+}
+
+// Run splits cfg and the workers between the agents, starts them together and
+// returns their merged metrics along with each agent's own. Cancelling ctx
+// stops every agent gracefully, and what they ran until then is still merged.
+// If any agent fails, the others are stopped and an error is returned.
+func (c *Coordinator) Run(ctx context.Context, cfg *config.Config, workers int) (*config.Metrics, []*config.Metrics, error) {
+	n := len(c.agents)
+	if n == 0 {
+		return nil, nil, fmt.Errorf("no agents given")
+	}
+	if workers < n {
+		return nil, nil, fmt.Errorf("%d workers cannot be split between %d agents", workers, n)
+	}
+	if cfg.Model != "closed" && cfg.LoadPattern.Type != "curve" && cfg.LoadPattern.StartRPS < n {
+		return nil, nil, fmt.Errorf("start_rps %d cannot be split between %d agents", cfg.LoadPattern.StartRPS, n)
+	}
+	// A share of 0 means unset or unlimited, so a limit below the number of
+	// agents would lift it for some of them
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"max_rps", cfg.MaxRPS},
+		{"warmup rps", cfg.Warmup.RPS},
+		{"concurrency max", cfg.Concurrency.Max},
+	} {
+		if limit.value > 0 && limit.value < n {
+			return nil, nil, fmt.Errorf("%s %d cannot be split between %d agents", limit.name, limit.value, n)
+		}
+	}
+
+	// Check every agent is reachable and idle before starting any
+	for _, agent := range c.agents {
+		var status AgentStatus
+		if err := c.call(ctx, http.MethodGet, agent+"/status", nil, &status, requestTimeout); err != nil {
+			return nil, nil, fmt.Errorf("agent %s: %w", agent, err)
+		}
+		if status.Running {
+			return nil, nil, fmt.Errorf("agent %s is already running a test", agent)
+		}
+	}
+
+	// Jobs are not tied to ctx: an interrupt stops the agents through /stop,
+	// so they still report what they ran
+	runCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	start := time.Now().Add(startDelay)
+	results := make([]*config.Metrics, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, agent := range c.agents {
+		wg.Add(1)
+		go func(i int, agent string) {
+			defer wg.Done()
+			job := Job{Config: cfg.Share(i, n), Workers: config.Split(workers, i, n), Start: start}
+			var m config.Metrics
+			if err := c.call(runCtx, http.MethodPost, agent+"/run", &job, &m, 0); err != nil {
+				errs[i] = fmt.Errorf("agent %s: %w", agent, err)
+				c.stopAll(i)
+				return
+			}
+			results[i] = &m
+		}(i, agent)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		c.stopAll(-1)
+		<-done
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, results, err
+		}
+	}
+	return worker.Merge(results), results, nil
+}
+
+// stopAll asks every agent but skip to stop its test
+func (c *Coordinator) stopAll(skip int) {
+	for i, agent := range c.agents {
+		if i != skip {
+			// An agent that has already finished answers with an error
+			c.call(context.Background(), http.MethodPost, agent+"/stop", nil, nil, requestTimeout)
+		}
+	}
+}
+
+// call sends body as JSON and decodes the response into out, or returns the
+// error the agent responded with. A zero timeout waits indefinitely.
+func (c *Coordinator) call(ctx context.Context, method, url string, body, out interface{}, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error reaching agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorBody
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("agent returned %s", resp.Status)
+		}
+		return fmt.Errorf("%s", e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from agent: %w", err)
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, errorBody{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+package distributed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+// startAgents starts n agents on localhost and returns their addresses
+func startAgents(t *testing.T, n int) []string {
+	t.Helper()
+	addrs := make([]string, n)
+	for i := range addrs {
+		server := httptest.NewServer(NewAgent().Handler())
+		t.Cleanup(server.Close)
+		addrs[i] = server.URL
+	}
+	return addrs
+}
+
+func TestCoordinator_Run(t *testing.T) {
+	// Each agent reads its own rows of the CSV and tags requests with its
+	// partition, so the target can check no row is sent by two agents
+	dir := t.TempDir()
+	rows := ""
+	for i := 0; i < 9; i++ {
+		rows += strconv.Itoa(i) + "\n"
+	}
+	csv := filepath.Join(dir, "ids.csv")
+	if err := os.WriteFile(csv, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]string) // row to partition
+	var conflict string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, partition := r.Header.Get("X-Id"), r.Header.Get("X-Partition")
+		mu.Lock()
+		if p, ok := seen[id]; ok && p != partition {
+			conflict = id
+		}
+		seen[id] = partition
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{{
+			Name:   "ids",
+			URL:    target.URL,
+			Method: "GET",
+			Headers: map[string]string{
+				"X-Id":        `{{ readCSV "` + csv + `" }}`,
+				"X-Partition": "{{ partition }}",
+			},
+		}},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 100},
+		Duration:    time.Second,
+	}
+	m, results, err := NewCoordinator(startAgents(t, 3)).Run(context.Background(), cfg, 6)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected a result per agent, got %d", len(results))
+	}
+	var sum int64
+	for i, r := range results {
+		sum += r.TotalRequests
+		if r.PeakWorkers != 2 {
+			t.Errorf("Expected agent %d to run 2 of the 6 workers, got %d", i, r.PeakWorkers)
+		}
+	}
+	if m.TotalRequests != sum || m.Endpoints[0].TotalRequests != sum || m.ResponseTimeStats.Histogram.Count() != sum {
+		t.Errorf("Expected the merged counts and histograms to add up to %d, got %d, %d and %d",
+			sum, m.TotalRequests, m.Endpoints[0].TotalRequests, m.ResponseTimeStats.Histogram.Count())
+	}
+	if m.TotalRequests < 90 || m.TotalRequests > 110 {
+		t.Errorf("Expected about 100 requests at 100 RPS split between agents, got %d", m.TotalRequests)
+	}
+	if m.PeakWorkers != 6 {
+		t.Errorf("Expected 6 workers in total, got %d", m.PeakWorkers)
+	}
+
+	if conflict != "" {
+		t.Errorf("Expected each row to be read by one agent only, row %s was read by two", conflict)
+	}
+	for id, partition := range seen {
+		row, _ := strconv.Atoi(id)
+		if strconv.Itoa(row%3) != partition {
+			t.Errorf("Expected row %d in partition %d, got %s", row, row%3, partition)
+		}
+	}
+}
+
+func TestCoordinator_Stop(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Endpoints:   []config.Endpoint{{URL: target.URL, Method: "GET"}},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 20},
+		Duration:    time.Minute,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(startDelay + 500*time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	m, _, err := NewCoordinator(startAgents(t, 2)).Run(ctx, cfg, 2)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > startDelay+5*time.Second {
+		t.Errorf("Expected the agents to stop when cancelled, took %v", elapsed)
+	}
+	if m.TotalRequests == 0 {
+		t.Error("Expected the results up to the stop to be merged")
+	}
+}
+
+func TestCoordinator_Errors(t *testing.T) {
+	cfg := &config.Config{
+		Endpoints:   []config.Endpoint{{URL: "http://127.0.0.1:1", Method: "GET"}},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 1},
+		Duration:    time.Second,
+	}
+	agents := startAgents(t, 2)
+
+	if _, _, err := NewCoordinator(agents).Run(context.Background(), cfg, 2); err == nil ||
+		!strings.Contains(err.Error(), "cannot be split") {
+		t.Errorf("Expected a rate too low to split to be rejected, got %v", err)
+	}
+	cfg.LoadPattern.StartRPS = 10
+	cfg.MaxRPS = 2
+	if _, _, err := NewCoordinator(startAgents(t, 3)).Run(context.Background(), cfg, 3); err == nil ||
+		!strings.Contains(err.Error(), "max_rps 2 cannot be split") {
+		t.Errorf("Expected a max_rps below the number of agents to be rejected, got %v", err)
+	}
+	cfg.MaxRPS = 0
+	cfg.Concurrency.Max = 2
+	if _, _, err := NewCoordinator(startAgents(t, 3)).Run(context.Background(), cfg, 3); err == nil ||
+		!strings.Contains(err.Error(), "concurrency max 2 cannot be split") {
+		t.Errorf("Expected a concurrency max below the number of agents to be rejected, got %v", err)
+	}
+	cfg.Concurrency.Max = 0
+	if _, _, err := NewCoordinator(append(agents, "127.0.0.1:1")).Run(context.Background(), cfg, 6); err == nil ||
+		!strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("Expected an unreachable agent to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// highestTrackable is the largest latency a histogram resolves. Longer values
+// are counted in the top bucket; Max still reports them exactly.
+const highestTrackable = time.Hour
+
+// Histogram is a high dynamic range histogram of durations in the style of
+// HdrHistogram. Values from one microsecond to an hour are kept to a fixed
+// number of significant decimal digits, so memory use does not grow with the
+// number of values recorded and every value counts towards the percentiles.
+//
+// Recording is lock-free. Counts are allocated lazily per power-of-two range,
+// so a histogram only pays for the ranges its values actually fall in.
+type Histogram struct {
+	sigFigs   int
+	halfCount int   // sub-buckets per power-of-two range
+	halfMag   uint  // log2(halfCount)
+	subMask   int64 // covers the first two ranges, which share a resolution
+	highest   int64 // highest trackable value in microseconds
+	chunks    []atomic.Pointer[[]uint64]
+	count     atomic.Int64
+	sum       atomic.Int64 // nanoseconds
+	min       atomic.Int64 // nanoseconds, math.MaxInt64 until the first value
+	max       atomic.Int64 // nanoseconds
+}
+
+// Bin is a range of equal values and how many recorded values fell in it
+type Bin struct {
+	Value time.Duration // lowest value in the range
+	Width time.Duration
+	Count uint64
+}
+
+// NewHistogram creates a histogram that keeps values to the given number of
+// significant decimal digits, between 1 and 5
+func NewHistogram(sigFigs int) *Histogram {
+	h := &Histogram{}
+	h.init(sigFigs)
+	return h
+}
+
+// init sizes an empty histogram for the given precision
+func (h *Histogram) init(sigFigs int) {
+	if sigFigs < 1 {
+		sigFigs = 1
+	}
+	if sigFigs > 5 {
+		sigFigs = 5
+	}
+
+	largest := 2 * int64(math.Pow10(sigFigs))
+	subCount := int64(1) << bits.Len64(uint64(largest-1))
+	highest := int64(highestTrackable / time.Microsecond)
+
+	ranges := 1
+	for smallestUntrackable := subCount; smallestUntrackable <= highest; smallestUntrackable <<= 1 {
+		ranges++
+	}
+
+	h.sigFigs = sigFigs
+	h.halfCount = int(subCount / 2)
+	h.halfMag = uint(bits.Len64(uint64(subCount/2)) - 1)
+	h.subMask = subCount - 1
+	h.highest = highest
+	h.chunks = make([]atomic.Pointer[[]uint64], ranges+1)
+	h.count.Store(0)
+	h.sum.Store(0)
+	h.min.Store(math.MaxInt64)
+	h.max.Store(0)
+}
+
+// Record adds a single duration to the histogram
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordN(d, 1)
+}
+
+// RecordN adds n occurrences of a duration to the histogram
+func (h *Histogram) RecordN(d time.Duration, n uint64) {
+	if n == 0 {
+		return
+	}
+	ns := int64(d)
+	if ns < 0 {
+		ns = 0
+	}
+
+	index := h.countsIndex(ns / int64(time.Microsecond))
+	atomic.AddUint64(&h.chunk(index >> h.halfMag)[index&(h.halfCount-1)], n)
+
+	h.count.Add(int64(n))
+	h.sum.Add(ns * int64(n))
+	for {
+		current := h.min.Load()
+		if ns >= current || h.min.CompareAndSwap(current, ns) {
+			break
+		}
+	}
+	for {
+		current := h.max.Load()
+		if ns <= current || h.max.CompareAndSwap(current, ns) {
+			break
+		}
+	}
+}
+
+// Merge adds every value recorded in other to h. Histograms of a different
+// precision are merged at the precision of h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.Count() == 0 {
+		return
+	}
+
+	if other.sigFigs != h.sigFigs {
+		for _, bin := range other.Bins() {
+			h.RecordN(bin.Value+bin.Width/2, bin.Count)
+		}
+		h.mergeExtremes(other)
+		return
+	}
+
+	for i := range other.chunks {
+		src := other.chunks[i].Load()
+		if src == nil {
+			continue
+		}
+		dst := h.chunk(i)
+		for j := range *src {
+			if c := atomic.LoadUint64(&(*src)[j]); c > 0 {
+				atomic.AddUint64(&dst[j], c)
+			}
+		}
+	}
+	h.count.Add(other.count.Load())
+	h.sum.Add(other.sum.Load())
+	h.mergeExtremes(other)
+}
+
+// mergeExtremes takes the exact min and max of other where they extend h
+func (h *Histogram) mergeExtremes(other *Histogram) {
+	if m := other.min.Load(); m < h.min.Load() {
+		h.min.Store(m)
+	}
+	if m := other.max.Load(); m > h.max.Load() {
+		h.max.Store(m)
+	}
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	return h.count.Load()
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	if h.Count() == 0 {
+		return 0
+	}
+	return time.Duration(h.min.Load())
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max.Load())
+}
+
+// Sum returns the exact total of the recorded values
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(h.sum.Load())
+}
+
+// Mean returns the exact mean of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum.Load() / count)
+}
+
+// Quantile returns the value below which the given fraction of recorded values
+// fall, e.g. 0.99 for p99. The result is the highest value equivalent to the
+// one at that rank at the histogram's precision, clamped to the exact min and max.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	var total uint64
+	for i := range h.chunks {
+		if c := h.chunks[i].Load(); c != nil {
+			for j := range *c {
+				total += atomic.LoadUint64(&(*c)[j])
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i := range h.chunks {
+		c := h.chunks[i].Load()
+		if c == nil {
+			continue
+		}
+		for j := range *c {
+			seen += atomic.LoadUint64(&(*c)[j])
+			if seen < rank {
+				continue
+			}
+			value := h.highestEquivalent(h.valueFromIndex(i<<h.halfMag + j))
+			d := time.Duration(value) * time.Microsecond
+			if d > h.Max() {
+				d = h.Max()
+			}
+			if d < h.Min() {
+				d = h.Min()
+			}
+			return d
+		}
+	}
+	return h.Max()
+}
+
+// Bins returns the non-empty ranges of the histogram in ascending order
+func (h *Histogram) Bins() []Bin {
+	var bins []Bin
+	for i := range h.chunks {
+		c := h.chunks[i].Load()
+		if c == nil {
+			continue
+		}
+		for j := range *c {
+			count := atomic.LoadUint64(&(*c)[j])
+			if count == 0 {
+				continue
+			}
+			value := h.valueFromIndex(i<<h.halfMag + j)
+			bins = append(bins, Bin{
+				Value: time.Duration(value) * time.Microsecond,
+				Width: time.Duration(h.rangeSize(value)) * time.Microsecond,
+				Count: count,
+			})
+		}
+	}
+	return bins
+}
+
+// chunk returns the counts for a power-of-two range, allocating them on first use
+func (h *Histogram) chunk(i int) []uint64 {
+	if c := h.chunks[i].Load(); c != nil {
+		return *c
+	}
+	counts := make([]uint64, h.halfCount)
+	h.chunks[i].CompareAndSwap(nil, &counts)
+	return *h.chunks[i].Load()
+}
+
+// countsIndex maps a value in microseconds to its position in the counts
+func (h *Histogram) countsIndex(v int64) int {
+	if v > h.highest {
+		v = h.highest
+	}
+	bucket := h.bucketIndex(v)
+	sub := int(v >> uint(bucket))
+	return (bucket+1)<<h.halfMag + sub - h.halfCount
+}
+
+// bucketIndex returns the power-of-two range a value falls in
+func (h *Histogram) bucketIndex(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subMask))
+	return pow2Ceiling - int(h.halfMag) - 1
+}
+
+// valueFromIndex returns the lowest value at a position in the counts
+func (h *Histogram) valueFromIndex(index int) int64 {
+	bucket := index>>h.halfMag - 1
+	sub := index&(h.halfCount-1) + h.halfCount
+	if bucket < 0 {
+		sub -= h.halfCount
+		bucket = 0
+	}
+	return int64(sub) << uint(bucket)
+}
+
+// rangeSize returns how many values share a position with v
+func (h *Histogram) rangeSize(v int64) int64 {
+	return int64(1) << uint(h.bucketIndex(v))
+}
+
+// highestEquivalent returns the highest value sharing a position with v
+func (h *Histogram) highestEquivalent(v int64) int64 {
+	return v + h.rangeSize(v) - 1
+}
+
+// histogramJSON is the serialized form of a histogram. Bins are
+// [lowest value in microseconds, count] pairs.
+type histogramJSON struct {
+	SignificantFigures int        `json:"significant_figures"`
+	Count              int64      `json:"count"`
+	SumNanos           int64      `json:"sum_ns"`
+	MinNanos           int64      `json:"min_ns"`
+	MaxNanos           int64      `json:"max_ns"`
+	Bins               [][2]int64 `json:"bins"`
+}
+
+// MarshalJSON serializes the non-empty bins and exact summary values
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	out := histogramJSON{
+		SignificantFigures: h.sigFigs,
+		Count:              h.Count(),
+		SumNanos:           h.sum.Load(),
+		MinNanos:           int64(h.Min()),
+		MaxNanos:           int64(h.Max()),
+		Bins:               [][2]int64{},
+	}
+	for _, bin := range h.Bins() {
+		out.Bins = append(out.Bins, [2]int64{int64(bin.Value / time.Microsecond), int64(bin.Count)})
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON restores a histogram serialized by MarshalJSON. Bins outside
+// the trackable range, or with a negative count, are rejected.
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var in histogramJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	h.init(in.SignificantFigures)
+	for _, bin := range in.Bins {
+		if bin[0] < 0 || bin[0] > h.highest {
+			return fmt.Errorf("histogram bin %dµs is outside the trackable range of 0 to %dµs", bin[0], h.highest)
+		}
+		if bin[1] < 0 {
+			return fmt.Errorf("histogram bin %dµs has a negative count %d", bin[0], bin[1])
+		}
+		index := h.countsIndex(bin[0])
+		h.chunk(index >> h.halfMag)[index&(h.halfCount-1)] += uint64(bin[1])
+	}
+	h.count.Store(in.Count)
+	h.sum.Store(in.SumNanos)
+	if in.Count > 0 {
+		h.min.Store(in.MinNanos)
+	}
+	h.max.Store(in.MaxNanos)
+	return nil
+}
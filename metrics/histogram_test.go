@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogram_Quantiles(t *testing.T) {
+	h := NewHistogram(3)
+	for i := 1; i <= 1000000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 500 * time.Millisecond},
+		{0.95, 950 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+		{0.999, 999 * time.Millisecond},
+		{0.99999, 999990 * time.Microsecond},
+	}
+
+	for _, tt := range tests {
+		got := h.Quantile(tt.q)
+		if err := math.Abs(float64(got-tt.want)) / float64(tt.want); err > 0.001 {
+			t.Errorf("Quantile(%v) = %v, want %v within 0.1%%", tt.q, got, tt.want)
+		}
+	}
+
+	if h.Count() != 1000000 {
+		t.Errorf("Count() = %d, want 1000000", h.Count())
+	}
+	if h.Min() != time.Microsecond || h.Max() != time.Second {
+		t.Errorf("Expected exact min and max, got %v and %v", h.Min(), h.Max())
+	}
+	if want := 500000500 * time.Nanosecond; h.Mean() != want {
+		t.Errorf("Mean() = %v, want %v", h.Mean(), want)
+	}
+}
+
+func TestHistogram_ConcurrentMerge(t *testing.T) {
+	const workers, perWorker = 8, 10000
+
+	recorders := make([]*Histogram, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		recorders[w] = NewHistogram(3)
+		wg.Add(1)
+		go func(h *Histogram, offset int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				h.Record(time.Duration(offset+i) * time.Millisecond)
+			}
+		}(recorders[w], w*perWorker)
+	}
+	wg.Wait()
+
+	merged := NewHistogram(3)
+	for _, h := range recorders {
+		merged.Merge(h)
+	}
+
+	if merged.Count() != workers*perWorker {
+		t.Fatalf("Count() = %d, want %d", merged.Count(), workers*perWorker)
+	}
+	if got, want := merged.Quantile(0.5), 40*time.Second; math.Abs(float64(got-want))/float64(want) > 0.001 {
+		t.Errorf("Quantile(0.5) = %v, want %v", got, want)
+	}
+
+	// A lower precision histogram merges at the precision of the target
+	coarse := NewHistogram(2)
+	coarse.Merge(merged)
+	if coarse.Count() != merged.Count() || coarse.Max() != merged.Max() {
+		t.Errorf("Expected counts and extremes to survive a cross-precision merge")
+	}
+}
+
+func TestHistogram_JSONRoundTrip(t *testing.T) {
+	h := NewHistogram(3)
+	for i := 0; i < 1000; i++ {
+		h.Record(time.Duration(i*i) * time.Microsecond)
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+
+	var restored Histogram
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+
+	if restored.Count() != h.Count() || restored.Mean() != h.Mean() || restored.Min() != h.Min() || restored.Max() != h.Max() {
+		t.Errorf("Summary values changed in round trip")
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		if restored.Quantile(q) != h.Quantile(q) {
+			t.Errorf("Quantile(%v) = %v after round trip, want %v", q, restored.Quantile(q), h.Quantile(q))
+		}
+	}
+
+	for _, bins := range []string{`[[-1,1]]`, `[[3600000001,1]]`, `[[100,-1]]`} {
+		data := `{"significant_figures":3,"count":1,"bins":` + bins + `}`
+		if err := json.Unmarshal([]byte(data), &restored); err == nil {
+			t.Errorf("Expected bins %s to be rejected", bins)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package mock is a target server for dry runs, demos and the tool's own
+// tests, so they need neither a real service nor a network. Each route
+// answers with a configurable latency distribution and error rate, and can
+// echo plain, protobuf or gRPC requests back. Draws come from a seeded
+// source, so a run can be reproduced.
+package mock
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"protobuf/config"
+)
+
+// Echo modes of a route
+const (
+	EchoBody     = "body"     // echo the request body and content type
+	EchoProtobuf = "protobuf" // echo a protobuf message, rejecting malformed ones
+	EchoGRPC     = "grpc"     // echo the messages of a gRPC call
+)
+
+// gRPC status codes the server answers with
+const (
+	grpcOK            = 0
+	grpcUnimplemented = 12
+	grpcInternal      = 13
+	grpcUnavailable   = 14
+)
+
+// Config lists the routes a server answers
+type Config struct {
+	Routes []Route `yaml:"routes"`
+	Seed   int64   `yaml:"seed"` // seeds the latency and error draws
+}
+
+// Route describes how the server answers requests to one path. Requests are
+// delayed by a latency drawn from its distribution, then fail with
+// ErrorStatus at ErrorRate, or else are answered or echoed.
+type Route struct {
+	Path        string            `yaml:"path"`         // exact path, or a prefix when it ends in /
+	Method      string            `yaml:"method"`       // any method when empty
+	Status      int               `yaml:"status"`       // 200 when unset
+	Headers     map[string]string `yaml:"headers"`      // added to successful responses
+	Body        string            `yaml:"body"`         // ignored when echoing
+	Echo        string            `yaml:"echo"`         // body, protobuf or grpc
+	Latency     config.ThinkTime  `yaml:"latency"`      // same distributions as think time
+	ErrorRate   float64           `yaml:"error_rate"`   // fraction of requests that fail, 0 to 1
+	ErrorStatus int               `yaml:"error_status"` // 500 when unset; gRPC calls fail with UNAVAILABLE
+}
+
+// Validate checks the routes
+func (c *Config) Validate() error {
+	if len(c.Routes) == 0 {
+		return fmt.Errorf("at least one route is required")
+	}
+	for i := range c.Routes {
+		if err := c.Routes[i].Validate(); err != nil {
+			return fmt.Errorf("route %d (%s): %w", i+1, c.Routes[i].Path, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the route's path, echo mode, latency and error settings
+func (r *Route) Validate() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	switch r.Echo {
+	case "", EchoBody, EchoProtobuf, EchoGRPC:
+	default:
+		return fmt.Errorf("unknown echo mode: %s", r.Echo)
+	}
+	if err := r.Latency.Validate(); err != nil {
+		return fmt.Errorf("invalid latency: %w", err)
+	}
+	if r.ErrorRate < 0 || r.ErrorRate > 1 {
+		return fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if r.Status != 0 && (r.Status < 100 || r.Status > 599) {
+		return fmt.Errorf("invalid status: %d", r.Status)
+	}
+	if r.ErrorStatus != 0 && (r.ErrorStatus < 100 || r.ErrorStatus > 599) {
+		return fmt.Errorf("invalid error_status: %d", r.ErrorStatus)
+	}
+	return nil
+}
+
+// DefaultConfig returns the routes served when none are configured: a fast
+// JSON API at /, a slow and a flaky endpoint, and the three echo modes
+func DefaultConfig() *Config {
+	json := map[string]string{"Content-Type": "application/json"}
+	return &Config{
+		Routes: []Route{
+			{Path: "/slow", Headers: json, Body: `{"ok":true}`,
+				Latency: config.ThinkTime{Distribution: "normal", Mean: 200 * time.Millisecond, StdDev: 50 * time.Millisecond}},
+			{Path: "/flaky", Headers: json, Body: `{"ok":true}`, ErrorRate: 0.1, ErrorStatus: http.StatusServiceUnavailable},
+			{Path: "/echo", Echo: EchoBody},
+			{Path: "/proto", Method: http.MethodPost, Echo: EchoProtobuf},
+			{Path: "/echo.Echo/", Method: http.MethodPost, Echo: EchoGRPC},
+			{Path: "/", Headers: json, Body: `{"ok":true}`,
+				Latency: config.ThinkTime{Distribution: "exponential", Mean: 5 * time.Millisecond}},
+		},
+	}
+}
+
+// Server answers requests according to its routes. It is an http.Handler;
+// gRPC routes need it served over HTTP/2, which net/http negotiates over TLS.
+type Server struct {
+	routes []Route
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewServer creates a server for the given routes
+func NewServer(cfg *Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Server{
+		routes: cfg.Routes,
+		rng:    rand.New(rand.NewSource(cfg.Seed)),
+	}, nil
+}
+
+// ServeHTTP answers a request with the first route that matches it
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := s.match(r)
+	if route == nil {
+		http.NotFound(w, r)
+		return
+	}
+	delay, fail := s.draw(route)
+
+	// The body is read first, so the latency adds to the time taken to answer
+	// once the whole request has arrived
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	if route.Echo == EchoGRPC {
+		serveGRPC(w, r, body, fail)
+		return
+	}
+	if fail {
+		status := route.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, "injected error", status)
+		return
+	}
+
+	for name, value := range route.Headers {
+		w.Header().Set(name, value)
+	}
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	switch route.Echo {
+	case EchoBody:
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	case EchoProtobuf:
+		if err := checkMessage(body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid protobuf message: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(status)
+		w.Write(body)
+	default:
+		w.WriteHeader(status)
+		io.WriteString(w, route.Body)
+	}
+}
+
+// match returns the first route for the request's method and path, or nil
+func (s *Server) match(r *http.Request) *Route {
+	for i := range s.routes {
+		route := &s.routes[i]
+		if route.Method != "" && !strings.EqualFold(route.Method, r.Method) {
+			continue
+		}
+		if route.Path == r.URL.Path || (strings.HasSuffix(route.Path, "/") && strings.HasPrefix(r.URL.Path, route.Path)) {
+			return route
+		}
+	}
+	return nil
+}
+
+// draw returns the latency to add to a request and whether it fails
+func (s *Server) draw(route *Route) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delay := route.Latency.Draw(s.rng)
+	fail := route.ErrorRate > 0 && s.rng.Float64() < route.ErrorRate
+	return delay, fail
+}
+
+// serveGRPC echoes the messages of a gRPC call back. Messages are checked to
+// be well-formed protobuf, and compressed ones are refused.
+func serveGRPC(w http.ResponseWriter, r *http.Request, body []byte, fail bool) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC needs HTTP/2 and an application/grpc content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if fail {
+		writeGRPCStatus(w, grpcUnavailable, "injected error")
+		return
+	}
+
+	var messages [][]byte
+	for len(body) > 0 {
+		if len(body) < 5 {
+			writeGRPCStatus(w, grpcInternal, "truncated message frame")
+			return
+		}
+		compressed, size := body[0], binary.BigEndian.Uint32(body[1:5])
+		if compressed != 0 {
+			writeGRPCStatus(w, grpcUnimplemented, "compressed messages are not supported")
+			return
+		}
+		if uint32(len(body)-5) < size {
+			writeGRPCStatus(w, grpcInternal, "truncated message frame")
+			return
+		}
+		message := body[5 : 5+size]
+		if err := checkMessage(message); err != nil {
+			writeGRPCStatus(w, grpcInternal, fmt.Sprintf("invalid protobuf message: %v", err))
+			return
+		}
+		messages = append(messages, message)
+		body = body[5+size:]
+	}
+
+	w.WriteHeader(http.StatusOK)
+	for _, message := range messages {
+		var header [5]byte
+		binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+		w.Write(header[:])
+		w.Write(message)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(grpcOK))
+}
+
+// writeGRPCStatus ends a call with no messages, sending its status in the
+// headers as gRPC allows
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkMessage checks that b is a well-formed protobuf message. Without its
+// schema only the wire format can be checked, not the field types.
+func checkMessage(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"protobuf/config"
+)
+
+// newServer starts a mock server for the routes
+func newServer(t *testing.T, routes ...Route) *httptest.Server {
+	t.Helper()
+	s, err := NewServer(&Config{Routes: routes, Seed: 1})
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// send sends a request and returns the response status, body and headers
+func send(t *testing.T, method, url, contentType string, body []byte) (int, string, http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data), resp.Header
+}
+
+// testMessage is a protobuf message with a string and a varint field
+func testMessage() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "hello")
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendVarint(b, 42)
+}
+
+func TestServer_Routes(t *testing.T) {
+	server := newServer(t,
+		Route{Path: "/created", Method: "POST", Status: http.StatusCreated, Body: `{"id":1}`,
+			Headers: map[string]string{"Content-Type": "application/json"}},
+		Route{Path: "/posts/", Body: "post"},
+		Route{Path: "/echo", Echo: EchoBody},
+	)
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"POST", "/created", http.StatusCreated, `{"id":1}`},
+		{"GET", "/created", http.StatusNotFound, "404 page not found\n"},
+		{"GET", "/posts/1", http.StatusOK, "post"},
+		{"GET", "/posts", http.StatusNotFound, "404 page not found\n"},
+		{"PUT", "/echo", http.StatusOK, "ping"},
+	}
+	for _, tt := range tests {
+		code, body, header := send(t, tt.method, server.URL+tt.path, "text/plain", []byte("ping"))
+		if code != tt.code || body != tt.body {
+			t.Errorf("%s %s: expected %d %q, got %d %q", tt.method, tt.path, tt.code, tt.body, code, body)
+		}
+		if tt.path == "/created" && code == http.StatusCreated && header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected the route's headers, got %v", header)
+		}
+	}
+}
+
+func TestServer_ErrorRate(t *testing.T) {
+	server := newServer(t, Route{Path: "/", ErrorRate: 0.2, ErrorStatus: http.StatusServiceUnavailable})
+
+	failed := 0
+	for i := 0; i < 500; i++ {
+		code, _, _ := send(t, "GET", server.URL, "", nil)
+		switch code {
+		case http.StatusServiceUnavailable:
+			failed++
+		case http.StatusOK:
+		default:
+			t.Fatalf("Expected 200 or 503, got %d", code)
+		}
+	}
+	if failed < 70 || failed > 130 {
+		t.Errorf("Expected about 100 of 500 requests to fail, got %d", failed)
+	}
+}
+
+func TestServer_Latency(t *testing.T) {
+	server := newServer(t, Route{Path: "/", Latency: config.ThinkTime{Mean: 50 * time.Millisecond}})
+
+	start := time.Now()
+	send(t, "GET", server.URL, "", nil)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the response to take at least 50ms, took %v", elapsed)
+	}
+}
+
+func TestServer_ProtobufEcho(t *testing.T) {
+	server := newServer(t, Route{Path: "/proto", Echo: EchoProtobuf})
+
+	message := testMessage()
+	code, body, header := send(t, "POST", server.URL+"/proto", "application/x-protobuf", message)
+	if code != http.StatusOK || body != string(message) || header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("Expected the message echoed back, got %d %q %v", code, body, header)
+	}
+
+	// A length running past the end of the message
+	malformed := append(protowire.AppendTag(nil, 1, protowire.BytesType), 10, 'x')
+	if code, _, _ := send(t, "POST", server.URL+"/proto", "application/x-protobuf", malformed); code != http.StatusBadRequest {
+		t.Errorf("Expected a malformed message to be rejected, got %d", code)
+	}
+}
+
+func TestServer_GRPCEcho(t *testing.T) {
+	s, err := NewServer(&Config{Routes: []Route{
+		{Path: "/echo.Echo/Fail", ErrorRate: 1, Echo: EchoGRPC},
+		{Path: "/echo.Echo/", Echo: EchoGRPC},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(s)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	call := func(method string, frame []byte) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest("POST", server.URL+"/echo.Echo/"+method, bytes.NewReader(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, data
+	}
+
+	message := testMessage()
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	resp, data := call("Say", frame)
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if !bytes.Equal(data, frame) || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Expected the message echoed back with status 0, got %q and trailers %v", data, resp.Trailer)
+	}
+
+	if resp, _ := call("Fail", frame); resp.Header.Get("Grpc-Status") != "14" {
+		t.Errorf("Expected an injected error to end the call with UNAVAILABLE, got %v", resp.Header)
+	}
+	if resp, _ := call("Say", frame[:4]); resp.Header.Get("Grpc-Status") != "13" {
+		t.Errorf("Expected a truncated frame to end the call with INTERNAL, got %v", resp.Header)
+	}
+
+	// gRPC over HTTP/1 is refused
+	plain := httptest.NewServer(s)
+	defer plain.Close()
+	code, _, _ := send(t, "POST", plain.URL+"/echo.Echo/Say", "application/grpc", frame)
+	if code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected gRPC over HTTP/1 to be refused, got %d", code)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		route Route
+		err   string
+	}{
+		{Route{Path: "api"}, "path must start with /"},
+		{Route{Path: "/", Echo: "xml"}, "unknown echo mode"},
+		{Route{Path: "/", ErrorRate: 1.5}, "error_rate"},
+		{Route{Path: "/", Status: 42}, "invalid status"},
+		{Route{Path: "/", Latency: config.ThinkTime{Distribution: "uniform", Min: 2, Max: 1}}, "invalid latency"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Routes: []Route{tt.route}}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: expected an error containing %q, got %v", tt.route, tt.err, err)
+		}
+	}
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Expected the default routes to be valid, got %v", err)
+	}
+	if err := (&Config{}).Validate(); err == nil {
+		t.Error("Expected a config without routes to be rejected")
+	}
+}
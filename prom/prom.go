@@ -0,0 +1,165 @@
+// Package prom serves live test metrics in the Prometheus text exposition
+// format, so the offered load can be graphed next to the target's own metrics
+package prom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+// namespace prefixes every exported metric name
+const namespace = "stress_test_"
+
+// latencyBuckets are the upper bounds of the exported latency histograms,
+// in seconds
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Handler returns an HTTP handler that writes the metrics returned by source
+// on every scrape
+func Handler(source func() *config.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, source())
+	})
+}
+
+// Write writes m in the Prometheus text exposition format
+func Write(out io.Writer, m *config.Metrics) error {
+	w := bufio.NewWriter(out)
+
+	header(w, "requests_total", "counter", "Requests that received a response, by endpoint and HTTP status code.")
+	for _, e := range m.Endpoints {
+		for _, status := range sortedStatuses(e.StatusCodes) {
+			sample(w, "requests_total", labels("endpoint", e.Name, "status", strconv.Itoa(status)), float64(e.StatusCodes[status]))
+		}
+	}
+
+	header(w, "request_errors_total", "counter", "Failed requests, by endpoint and error class.")
+	for _, e := range m.Endpoints {
+		for _, class := range sortedClasses(e.Errors) {
+			sample(w, "request_errors_total", labels("endpoint", e.Name, "class", class), float64(e.Errors[class].Count))
+		}
+	}
+
+	header(w, "requests_dropped_total", "counter", "Requests scheduled but never sent because every worker was busy.")
+	sample(w, "requests_dropped_total", "", float64(m.DroppedRequests))
+	header(w, "requests_cancelled_total", "counter", "Requests queued or in flight at shutdown that never completed.")
+	sample(w, "requests_cancelled_total", "", float64(m.CancelledRequests))
+	header(w, "requests_late_total", "counter", "Requests sent later than the late threshold after their scheduled time.")
+	sample(w, "requests_late_total", "", float64(m.LateRequests))
+
+	header(w, "response_time_seconds", "histogram", "Response time from when each request was scheduled, by endpoint.")
+	for _, e := range m.Endpoints {
+		histogram(w, "response_time_seconds", e.Name, e.ResponseTimeStats.Histogram)
+	}
+	header(w, "service_time_seconds", "histogram", "Service time from when each request was sent, by endpoint.")
+	for _, e := range m.Endpoints {
+		histogram(w, "service_time_seconds", e.Name, e.LatencyStats.Histogram)
+	}
+
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"target_rps", "Rate the load pattern is asking for.", m.TargetRPS},
+		{"achieved_rps", "Rate achieved over the last metrics interval.", m.CurrentRPS},
+		{"active_workers", "Workers currently running.", float64(m.ActiveWorkers)},
+		{"in_flight_requests", "Requests sent and awaiting a response.", float64(m.InFlight)},
+		{"elapsed_seconds", "Time since the test started.", m.Elapsed.Seconds()},
+	}
+	for _, g := range gauges {
+		header(w, g.name, "gauge", g.help)
+		sample(w, g.name, "", g.value)
+	}
+
+	return w.Flush()
+}
+
+// header writes the HELP and TYPE lines of a metric
+func header(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", namespace, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", namespace, name, kind)
+}
+
+// sample writes a single sample line
+func sample(w *bufio.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s%s %s\n", namespace, name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// histogram writes the cumulative buckets, sum and count of a latency
+// histogram. Each HDR bin is counted in the first bucket its lowest value
+// falls in.
+func histogram(w *bufio.Writer, name, endpoint string, h *metrics.Histogram) {
+	if h == nil {
+		return
+	}
+
+	counts := make([]uint64, len(latencyBuckets))
+	for _, bin := range h.Bins() {
+		for i, bound := range latencyBuckets {
+			if bin.Value.Seconds() <= bound {
+				counts[i] += bin.Count
+				break
+			}
+		}
+	}
+
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += counts[i]
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		sample(w, name+"_bucket", labels("endpoint", endpoint, "le", le), float64(cumulative))
+	}
+	count := h.Count()
+	sample(w, name+"_bucket", labels("endpoint", endpoint, "le", "+Inf"), float64(count))
+	sample(w, name+"_sum", labels("endpoint", endpoint), h.Sum().Seconds())
+	sample(w, name+"_count", labels("endpoint", endpoint), float64(count))
+}
+
+// labels formats name/value pairs as a label set
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// escaper escapes label values
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedStatuses returns the status codes of a count map in ascending order
+func sortedStatuses(codes map[int]int64) []int {
+	statuses := make([]int, 0, len(codes))
+	for status := range codes {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	return statuses
+}
+
+// sortedClasses returns the error classes of a map in name order
+func sortedClasses(errs map[string]config.ErrorStats) []string {
+	classes := make([]string, 0, len(errs))
+	for class := range errs {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
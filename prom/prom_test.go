@@ -0,0 +1,63 @@
+package prom
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+func TestWrite(t *testing.T) {
+	h := metrics.NewHistogram(3)
+	h.Record(2 * time.Millisecond)
+	h.Record(20 * time.Millisecond)
+	h.Record(20 * time.Second)
+
+	m := &config.Metrics{
+		TargetRPS:     100,
+		CurrentRPS:    97.5,
+		ActiveWorkers: 8,
+		InFlight:      2,
+		Endpoints: []config.EndpointMetrics{{
+			Name:              `GET "users"`,
+			StatusCodes:       map[int]int64{200: 40, 503: 2},
+			Errors:            map[string]config.ErrorStats{"http_status": {Count: 2}, "timeout": {Count: 1}},
+			ResponseTimeStats: config.LatencyStats{Histogram: h},
+		}},
+	}
+
+	server := httptest.NewServer(Handler(func() *config.Metrics { return m }))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		`# TYPE stress_test_requests_total counter`,
+		`stress_test_requests_total{endpoint="GET \"users\"",status="503"} 2`,
+		`stress_test_request_errors_total{endpoint="GET \"users\"",class="timeout"} 1`,
+		`stress_test_response_time_seconds_bucket{endpoint="GET \"users\"",le="0.001"} 0`,
+		`stress_test_response_time_seconds_bucket{endpoint="GET \"users\"",le="0.0025"} 1`,
+		`stress_test_response_time_seconds_bucket{endpoint="GET \"users\"",le="0.025"} 2`,
+		`stress_test_response_time_seconds_bucket{endpoint="GET \"users\"",le="10"} 2`,
+		`stress_test_response_time_seconds_bucket{endpoint="GET \"users\"",le="+Inf"} 3`,
+		`stress_test_response_time_seconds_sum{endpoint="GET \"users\""} 20.022`,
+		`stress_test_response_time_seconds_count{endpoint="GET \"users\""} 3`,
+		`stress_test_target_rps 100`,
+		`stress_test_achieved_rps 97.5`,
+		`stress_test_in_flight_requests 2`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Expected output to contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Chart dimensions in SVG user units
+const (
+	chartWidth   = 860
+	chartHeight  = 260
+	marginLeft   = 64
+	marginRight  = 16
+	marginTop    = 12
+	marginBottom = 36
+	yTicks       = 5
+)
+
+// chartColors are assigned to lines in order
+var chartColors = []string{"#2563eb", "#dc2626", "#16a34a", "#9333ea", "#ea580c"}
+
+// point is a single value on a line chart
+type point struct {
+	X, Y float64
+}
+
+// line is a named series on a line chart
+type line struct {
+	Name   string
+	Points []point
+}
+
+// bar is a single bar on a bar chart
+type bar struct {
+	Label string
+	Value float64
+}
+
+// lineChart renders lines as an inline SVG. X values are seconds into the
+// test; yUnit is appended to the y axis labels.
+func lineChart(lines []line, yUnit string) template.HTML {
+	var maxX, maxY float64
+	for _, l := range lines {
+		for _, p := range l.Points {
+			maxX = math.Max(maxX, p.X)
+			maxY = math.Max(maxY, p.Y)
+		}
+	}
+	if maxX == 0 {
+		return template.HTML(`<p class="empty">No data</p>`)
+	}
+	maxY = niceCeiling(maxY)
+
+	var b strings.Builder
+	openChart(&b)
+	yAxis(&b, maxY, yUnit)
+
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	for i := 0; i <= 6; i++ {
+		x := maxX * float64(i) / 6
+		px := marginLeft + plotWidth*float64(i)/6
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`,
+			px, chartHeight-marginBottom+16, formatSeconds(x))
+	}
+
+	for i, l := range lines {
+		color := chartColors[i%len(chartColors)]
+		coords := make([]string, len(l.Points))
+		for j, p := range l.Points {
+			coords[j] = fmt.Sprintf("%.1f,%.1f", scaleX(p.X, maxX), scaleY(p.Y, maxY))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`,
+			color, strings.Join(coords, " "))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/><text x="%d" y="%d">%s</text>`,
+			marginLeft+10+i*120, chartHeight-12, color, marginLeft+24+i*120, chartHeight-3, template.HTMLEscapeString(l.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// barChart renders bars of equal width as an inline SVG, labelling every few bars
+func barChart(bars []bar, yUnit string) template.HTML {
+	var maxY float64
+	for _, br := range bars {
+		maxY = math.Max(maxY, br.Value)
+	}
+	if maxY == 0 {
+		return template.HTML(`<p class="empty">No data</p>`)
+	}
+	maxY = niceCeiling(maxY)
+
+	var b strings.Builder
+	openChart(&b)
+	yAxis(&b, maxY, yUnit)
+
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	width := plotWidth / float64(len(bars))
+	labelEvery := int(math.Ceil(float64(len(bars)) / 10))
+	for i, br := range bars {
+		x := marginLeft + width*float64(i)
+		y := scaleY(br.Value, maxY)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %g</title></rect>`,
+			x+1, y, math.Max(width-2, 1), float64(chartHeight-marginBottom)-y, chartColors[0],
+			template.HTMLEscapeString(br.Label), br.Value)
+		if i%labelEvery == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`,
+				x+width/2, chartHeight-marginBottom+16, template.HTMLEscapeString(br.Label))
+		}
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// openChart starts an SVG element
+func openChart(b *strings.Builder) {
+	fmt.Fprintf(b, `<svg viewBox="0 0 %d %d" class="chart" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+}
+
+// yAxis draws horizontal grid lines with labels from 0 to maxY
+func yAxis(b *strings.Builder, maxY float64, unit string) {
+	for i := 0; i <= yTicks; i++ {
+		v := maxY * float64(i) / yTicks
+		y := scaleY(v, maxY)
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="grid"/>`,
+			marginLeft, y, chartWidth-marginRight, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" text-anchor="end">%s%s</text>`,
+			marginLeft-6, y+4, formatNumber(v), unit)
+	}
+}
+
+// scaleX maps an x value to SVG coordinates
+func scaleX(x, maxX float64) float64 {
+	return marginLeft + x/maxX*float64(chartWidth-marginLeft-marginRight)
+}
+
+// scaleY maps a y value to SVG coordinates, with 0 at the bottom of the plot
+func scaleY(y, maxY float64) float64 {
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+	return marginTop + plotHeight - y/maxY*plotHeight
+}
+
+// niceCeiling rounds v up to 1, 2 or 5 times a power of ten
+func niceCeiling(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if v <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatNumber formats an axis value without needless decimals
+func formatNumber(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2g", v)
+}
+
+// formatSeconds formats an offset into the test as m:ss
+func formatSeconds(s float64) string {
+	total := int(math.Round(s))
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
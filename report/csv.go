@@ -0,0 +1,55 @@
+package report
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+
+	"protobuf/config"
+)
+
+// csvHeader names the columns of the CSV summary. Latencies are response
+// times in milliseconds.
+var csvHeader = []string{
+	"endpoint", "requests", "successful", "failed", "error_rate", "rps",
+	"mean_ms", "p50_ms", "p95_ms", "p99_ms", "p999_ms", "max_ms",
+}
+
+// writeCSV writes one summary row per endpoint followed by a row for the
+// whole run
+func writeCSV(file *os.File, r *Result) error {
+	w := csv.NewWriter(file)
+	w.Write(csvHeader)
+
+	m := r.Metrics
+	for _, e := range m.Endpoints {
+		w.Write(csvRow(e.Name, e.TotalRequests, e.SuccessfulRequests, e.FailedRequests, e.RPS, e.ResponseTimeStats))
+	}
+	w.Write(csvRow("total", m.TotalRequests, m.SuccessfulRequests, m.FailedRequests, m.AchievedRPS, m.ResponseTimeStats))
+
+	w.Flush()
+	return w.Error()
+}
+
+// csvRow formats a single summary row
+func csvRow(name string, total, successful, failed int64, rps float64, s config.LatencyStats) []string {
+	var errorRate float64
+	if total > 0 {
+		errorRate = float64(failed) / float64(total)
+	}
+	return []string{
+		name,
+		strconv.FormatInt(total, 10),
+		strconv.FormatInt(successful, 10),
+		strconv.FormatInt(failed, 10),
+		strconv.FormatFloat(errorRate, 'f', 4, 64),
+		strconv.FormatFloat(rps, 'f', 2, 64),
+		millis(s.Mean), millis(s.P50), millis(s.P95), millis(s.P99), millis(s.P999), millis(s.Max),
+	}
+}
+
+// millis formats a duration in milliseconds
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
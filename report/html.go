@@ -0,0 +1,203 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+//go:embed report.html.tmpl
+var htmlTemplate string
+
+// distributionBucketsPerDecade is how finely the latency distribution chart
+// divides each power of ten
+const distributionBucketsPerDecade = 10
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(v float64) string { return fmt.Sprintf("%.2f%%", 100*v) },
+	"rate": func(part, total int64) string {
+		if total == 0 {
+			return "0.00%"
+		}
+		return fmt.Sprintf("%.2f%%", 100*float64(part)/float64(total))
+	},
+	"ms": func(d time.Duration) string { return fmt.Sprintf("%.2f ms", float64(d)/float64(time.Millisecond)) },
+	"count": func(h *metrics.Histogram) int64 {
+		if h == nil {
+			return 0
+		}
+		return h.Count()
+	},
+	"phases": phaseRows,
+}).Parse(htmlTemplate))
+
+// htmlData is what the report template renders
+type htmlData struct {
+	*Result
+	Title        string
+	RPSChart     template.HTML
+	LatencyChart template.HTML
+	ErrorChart   template.HTML
+	Distribution template.HTML
+	Annotations  []config.Annotation
+	Errors       []namedError
+	StatusCodes  []statusCount
+	Traced       []tracedLink
+}
+
+type namedError struct {
+	Class string
+	config.ErrorStats
+}
+
+type statusCount struct {
+	Status int
+	Count  int64
+}
+
+type namedPhase struct {
+	Name string
+	config.LatencyStats
+}
+
+// phaseRows lists the request phases in the order they happen
+func phaseRows(p *config.PhaseStats) []namedPhase {
+	return []namedPhase{
+		{"DNS", p.DNS},
+		{"Connect", p.Connect},
+		{"TLS", p.TLS},
+		{"Write", p.Write},
+		{"Time to first byte", p.TTFB},
+		{"Transfer", p.Transfer},
+	}
+}
+
+type tracedLink struct {
+	config.TracedRequest
+	URL string
+}
+
+// WriteHTML writes a self-contained HTML report of the result. Charts are
+// inline SVG, so the report needs no network access to view.
+func WriteHTML(w io.Writer, r *Result) error {
+	m := r.Metrics
+	data := htmlData{
+		Result:       r,
+		Title:        fmt.Sprintf("Stress test %s", r.Started.Format("2006-01-02 15:04")),
+		RPSChart:     lineChart(rpsLines(m.TimeSeries), ""),
+		LatencyChart: lineChart(latencyLines(m.TimeSeries), " ms"),
+		ErrorChart:   lineChart(errorLines(m.TimeSeries), "%"),
+		Distribution: barChart(distribution(m.ResponseTimeStats.Histogram), ""),
+	}
+
+	for _, i := range m.TimeSeries {
+		for _, a := range i.Annotations {
+			a.At = a.At.Round(100 * time.Millisecond)
+			data.Annotations = append(data.Annotations, a)
+		}
+	}
+
+	for class, stats := range m.Errors {
+		data.Errors = append(data.Errors, namedError{Class: class, ErrorStats: stats})
+	}
+	sort.Slice(data.Errors, func(i, j int) bool { return data.Errors[i].Count > data.Errors[j].Count })
+
+	for status, count := range m.StatusCodes {
+		data.StatusCodes = append(data.StatusCodes, statusCount{Status: status, Count: count})
+	}
+	sort.Slice(data.StatusCodes, func(i, j int) bool { return data.StatusCodes[i].Status < data.StatusCodes[j].Status })
+
+	for _, t := range m.SlowestTraced {
+		link := tracedLink{TracedRequest: t}
+		if r.TraceURL != "" {
+			link.URL = strings.ReplaceAll(r.TraceURL, "{trace_id}", t.TraceID)
+		}
+		data.Traced = append(data.Traced, link)
+	}
+
+	return reportTemplate.Execute(w, data)
+}
+
+// intervalEnd returns the offset of the end of an interval in seconds
+func intervalEnd(i config.IntervalMetrics) float64 {
+	return (i.Start + i.Duration).Seconds()
+}
+
+// rpsLines charts target and achieved RPS
+func rpsLines(series []config.IntervalMetrics) []line {
+	target := line{Name: "Target RPS"}
+	achieved := line{Name: "Achieved RPS"}
+	for _, i := range series {
+		target.Points = append(target.Points, point{intervalEnd(i), i.TargetRPS})
+		achieved.Points = append(achieved.Points, point{intervalEnd(i), i.AchievedRPS})
+	}
+	return []line{target, achieved}
+}
+
+// latencyLines charts response time percentiles in milliseconds
+func latencyLines(series []config.IntervalMetrics) []line {
+	lines := []line{{Name: "p50"}, {Name: "p95"}, {Name: "p99"}}
+	for _, i := range series {
+		x := intervalEnd(i)
+		for j, d := range []time.Duration{i.P50, i.P95, i.P99} {
+			lines[j].Points = append(lines[j].Points, point{x, float64(d) / float64(time.Millisecond)})
+		}
+	}
+	return lines
+}
+
+// errorLines charts the error rate in percent
+func errorLines(series []config.IntervalMetrics) []line {
+	errs := line{Name: "Error rate"}
+	for _, i := range series {
+		errs.Points = append(errs.Points, point{intervalEnd(i), 100 * i.ErrorRate})
+	}
+	return []line{errs}
+}
+
+// distribution groups a latency histogram into logarithmic buckets, so both
+// the bulk of requests and the tail are visible on one chart
+func distribution(h *metrics.Histogram) []bar {
+	if h == nil || h.Count() == 0 {
+		return nil
+	}
+
+	bucket := func(d time.Duration) int {
+		us := math.Max(float64(d)/float64(time.Microsecond), 1)
+		return int(math.Floor(math.Log10(us) * distributionBucketsPerDecade))
+	}
+
+	bins := h.Bins()
+	first, last := bucket(bins[0].Value), bucket(bins[len(bins)-1].Value)
+	counts := make([]uint64, last-first+1)
+	for _, bin := range bins {
+		counts[bucket(bin.Value)-first] += bin.Count
+	}
+
+	bars := make([]bar, len(counts))
+	for i, count := range counts {
+		lower := time.Duration(math.Pow(10, float64(first+i)/distributionBucketsPerDecade)) * time.Microsecond
+		bars[i] = bar{Label: formatLatency(lower), Value: float64(count)}
+	}
+	return bars
+}
+
+// formatLatency formats a bucket bound compactly
+func formatLatency(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.3gs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.3gms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.3gµs", float64(d)/float64(time.Microsecond))
+	}
+}
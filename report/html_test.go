@@ -0,0 +1,56 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+func TestWriteHTML(t *testing.T) {
+	r := testResult()
+	r.Config = "endpoints:\n  - url: \"http://example.com/?a=<b>\"\n"
+	r.TraceURL = "http://jaeger.local/trace/{trace_id}"
+	r.Metrics.SlowestTraced = []config.TracedRequest{{Endpoint: "users", TraceID: "abc123", ResponseTime: time.Second, Status: 200}}
+
+	var out bytes.Buffer
+	if err := WriteHTML(&out, r); err != nil {
+		t.Fatalf("WriteHTML returned error: %v", err)
+	}
+	html := out.String()
+
+	if n := strings.Count(html, "<svg"); n != 4 {
+		t.Errorf("Expected 4 charts, got %d", n)
+	}
+	for _, want := range []string{
+		"<td>users</td>",
+		"p99 &lt; 50ms",
+		`href="http://jaeger.local/trace/abc123"`,
+		"a=&lt;b&gt;",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Expected report to contain %q", want)
+		}
+	}
+	if strings.Contains(html, "<script") || strings.Contains(html, "<link") {
+		t.Error("Expected a report without scripts or remote resources")
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	bars := distribution(testResult().Metrics.ResponseTimeStats.Histogram)
+
+	var total float64
+	for _, b := range bars {
+		total += b.Value
+	}
+	if total != 100 {
+		t.Errorf("Expected every request in the distribution, got %v", total)
+	}
+	// 1ms to just under 100ms, where the top bin starts, spans two decades
+	if len(bars) != 2*distributionBucketsPerDecade || bars[0].Label != "1ms" {
+		t.Errorf("Expected logarithmic buckets from 1ms, got %d starting at %s", len(bars), bars[0].Label)
+	}
+}
@@ -0,0 +1,64 @@
+package report
+
+import (
+	"encoding/xml"
+	"os"
+	"strconv"
+)
+
+// junitSuites is the root of a JUnit XML report
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes a JUnit XML report with one test case per check, so CI
+// systems show each limit as a passing or failing test
+func writeJUnit(file *os.File, r *Result) error {
+	suite := junitSuite{
+		Name:      "stress-test",
+		Tests:     len(r.Checks),
+		Time:      strconv.FormatFloat(r.Metrics.Elapsed.Seconds(), 'f', 3, 64),
+		Timestamp: r.Started.Format("2006-01-02T15:04:05"),
+		Cases:     []junitCase{},
+	}
+	for _, c := range r.Checks {
+		tc := junitCase{Name: c.Name, ClassName: "stress-test.checks", SystemOut: c.Message}
+		if !c.Passed {
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: c.Message}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := file.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(file)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := file.WriteString("\n")
+	return err
+}
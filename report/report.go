@@ -0,0 +1,92 @@
+// Package report exports the results of a test run in machine-readable
+// formats and reads them back
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"protobuf/config"
+)
+
+// Result is everything recorded about a finished test run
+type Result struct {
+	Started    time.Time       `json:"started"`
+	Duration   time.Duration   `json:"duration_ns"` // planned duration
+	Workers    int             `json:"workers"`
+	ConfigFile string          `json:"config_file"`
+	Config     string          `json:"config"`              // contents of the config file
+	TraceURL   string          `json:"trace_url,omitempty"` // link template for traced requests
+	Metrics    *config.Metrics `json:"metrics"`
+	Checks     []Check         `json:"checks"`
+}
+
+// Check is the outcome of comparing a result against a single limit
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"` // the observed value and the limit
+}
+
+// Passed reports whether every check passed
+func (r *Result) Passed() bool {
+	for _, c := range r.Checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes the result to path in the format its extension names: .json
+// for the full result, .csv for a per-endpoint summary or .xml for JUnit
+func Write(path string, r *Result) error {
+	var write func(*os.File, *Result) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		write = writeJSON
+	case ".csv":
+		write = writeCSV
+	case ".xml":
+		write = writeJUnit
+	default:
+		return fmt.Errorf("unknown output format %q, expected .json, .csv or .xml", filepath.Ext(path))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	if err := write(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// Load reads a result written in JSON
+func Load(path string) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading results: %w", err)
+	}
+	var r Result
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error parsing results %s: %w", path, err)
+	}
+	if r.Metrics == nil {
+		return nil, fmt.Errorf("results %s contain no metrics", path)
+	}
+	return &r, nil
+}
+
+// writeJSON writes the full result, indented for archiving and diffing
+func writeJSON(file *os.File, r *Result) error {
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,136 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+// testResult returns a result with one endpoint and a breached p99 SLO
+func testResult() *Result {
+	h := metrics.NewHistogram(3)
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	stats := config.LatencyStats{
+		Mean: h.Mean(), P50: h.Quantile(0.5), P99: h.Quantile(0.99), Max: h.Max(), Histogram: h,
+	}
+
+	m := &config.Metrics{
+		TotalRequests:      100,
+		SuccessfulRequests: 99,
+		FailedRequests:     1,
+		AchievedRPS:        10,
+		Elapsed:            10 * time.Second,
+		ResponseTimeStats:  stats,
+		Errors:             map[string]config.ErrorStats{"timeout": {Count: 1, Samples: []string{"timeout"}}},
+		StatusCodes:        map[int]int64{200: 99},
+		Endpoints: []config.EndpointMetrics{{
+			Name: "users", TotalRequests: 100, SuccessfulRequests: 99, FailedRequests: 1, RPS: 10,
+			ResponseTimeStats: stats,
+		}},
+		TimeSeries: []config.IntervalMetrics{{Duration: time.Second, Requests: 10, P99: 99 * time.Millisecond}},
+	}
+
+	return &Result{
+		Started:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Duration: 10 * time.Second,
+		Workers:  4,
+		Config:   "duration: 10s\n",
+		Metrics:  m,
+		Checks: []Check{
+			{Name: "p99 < 50ms", Passed: false, Message: "p99 was 99.007ms"},
+			{Name: "error_rate < 5%", Passed: true, Message: "error_rate was 1.00%"},
+		},
+	}
+}
+
+func TestWrite_JSONRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	want := testResult()
+	if err := Write(path, want); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	if got.Config != want.Config || got.Workers != want.Workers || !got.Started.Equal(want.Started) {
+		t.Errorf("Run details changed in round trip: %+v", got)
+	}
+	m := got.Metrics
+	if m.TotalRequests != 100 || m.StatusCodes[200] != 99 || m.Errors["timeout"].Count != 1 {
+		t.Errorf("Counts changed in round trip: %+v", m)
+	}
+	if len(m.Endpoints) != 1 || len(m.TimeSeries) != 1 || m.TimeSeries[0].P99 != 99*time.Millisecond {
+		t.Errorf("Breakdowns changed in round trip")
+	}
+	if h := m.ResponseTimeStats.Histogram; h == nil || h.Quantile(0.99) != want.Metrics.ResponseTimeStats.P99 {
+		t.Errorf("Expected the latency histogram to survive the round trip")
+	}
+	if got.Passed() || len(got.Checks) != 2 {
+		t.Errorf("Expected the failed p99 check to survive the round trip, got %+v", got.Checks)
+	}
+}
+
+func TestWrite_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.csv")
+	if err := Write(path, testResult()); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("Summary is not valid CSV: %v", err)
+	}
+
+	if len(rows) != 3 || rows[1][0] != "users" || rows[2][0] != "total" {
+		t.Fatalf("Expected a header, an endpoint row and a total row, got %v", rows)
+	}
+	if rows[2][4] != "0.0100" || rows[2][9] != "99.007" {
+		t.Errorf("Expected a 1%% error rate and 99ms p99, got %v", rows[2])
+	}
+}
+
+func TestWrite_JUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	if err := Write(path, testResult()); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned error: %v", err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("Report is not valid XML: %v", err)
+	}
+
+	suite := suites.Suites[0]
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("Expected 2 tests with 1 failure, got %d and %d", suite.Tests, suite.Failures)
+	}
+	if suite.Cases[0].Failure == nil || suite.Cases[1].Failure != nil {
+		t.Errorf("Expected only the p99 check to fail, got %+v", suite.Cases)
+	}
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	if err := Write(filepath.Join(t.TempDir(), "results.txt"), testResult()); err == nil {
+		t.Error("Expected an error for an unknown extension")
+	}
+}
@@ -0,0 +1,196 @@
+package requestlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// binaryMagic starts a log in the binary format
+const binaryMagic = "STRQLOG1"
+
+// maxBinaryHeader bounds the header and footer lengths read from a binary log
+const maxBinaryHeader = 64 << 20
+
+// binaryFooterMark takes the place of a record's intended time to start the
+// footer. No record can be that far from the one before it.
+const binaryFooterMark = math.MinInt64
+
+// The binary format is the magic, the header as length-prefixed JSON, then
+// one record after another as varints:
+//
+//	intended   nanoseconds since the previous record's intended time (signed)
+//	start      nanoseconds after intended (signed)
+//	latency    nanoseconds
+//	endpoint   index into the header's endpoints
+//	status
+//	error      length-prefixed error class, empty on success
+//	bytes out, bytes in, worker
+//
+// A typical record takes around 25 bytes. The footer follows the records as
+// binaryFooterMark, then length-prefixed JSON.
+
+// newBinaryEncoder writes the magic and header and returns a function that
+// writes each record
+func newBinaryEncoder(w *bufio.Writer, header Header) (func(*bufio.Writer, *Record) error, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.WriteString(binaryMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(data)))); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	prev := header.Started
+	buf := make([]byte, 0, 64)
+	return func(w *bufio.Writer, r *Record) error {
+		buf = binary.AppendVarint(buf[:0], int64(r.Intended.Sub(prev)))
+		buf = binary.AppendVarint(buf, int64(r.Start.Sub(r.Intended)))
+		buf = binary.AppendUvarint(buf, uint64(r.Latency))
+		buf = binary.AppendUvarint(buf, uint64(r.Endpoint))
+		buf = binary.AppendUvarint(buf, uint64(r.Status))
+		buf = binary.AppendUvarint(buf, uint64(len(r.Error)))
+		buf = append(buf, r.Error...)
+		buf = binary.AppendUvarint(buf, uint64(r.BytesOut))
+		buf = binary.AppendUvarint(buf, uint64(r.BytesIn))
+		buf = binary.AppendUvarint(buf, uint64(r.Worker))
+		prev = r.Intended
+		_, err := w.Write(buf)
+		return err
+	}, nil
+}
+
+// encodeBinaryFooter writes the mark and the footer
+func encodeBinaryFooter(w *bufio.Writer, footer *Footer) error {
+	data, err := json.Marshal(footer)
+	if err != nil {
+		return err
+	}
+	buf := binary.AppendVarint(nil, binaryFooterMark)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	_, err = w.Write(append(buf, data...))
+	return err
+}
+
+// newBinaryDecoder reads the magic and header and returns a function that
+// reads each record, or the footer
+func newBinaryDecoder(r *bufio.Reader) (Header, func(*bufio.Reader) (Record, *Footer, error), error) {
+	if _, err := r.Discard(len(binaryMagic)); err != nil {
+		return Header{}, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > maxBinaryHeader {
+		return Header{}, nil, fmt.Errorf("invalid header length")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Header{}, nil, fmt.Errorf("truncated header: %w", err)
+	}
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return Header{}, nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	prev := header.Started
+	return header, func(r *bufio.Reader) (Record, *Footer, error) {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			// A clean end of file can only fall between records
+			return Record{}, nil, err
+		}
+		if delta == binaryFooterMark {
+			footer, err := readBinaryFooter(r)
+			return Record{}, footer, err
+		}
+
+		d := fieldReader{r: r}
+		start := d.varint()
+		rec := Record{
+			Intended: prev.Add(time.Duration(delta)),
+			Latency:  time.Duration(d.uvarint()),
+			Endpoint: int(d.uvarint()),
+			Status:   int(d.uvarint()),
+			Error:    d.string(),
+			BytesOut: int64(d.uvarint()),
+			BytesIn:  int64(d.uvarint()),
+			Worker:   int(d.uvarint()),
+		}
+		if d.err != nil {
+			if d.err == io.EOF {
+				d.err = io.ErrUnexpectedEOF
+			}
+			return Record{}, nil, fmt.Errorf("truncated record: %w", d.err)
+		}
+		rec.Start = rec.Intended.Add(time.Duration(start))
+		prev = rec.Intended
+		return rec, nil, nil
+	}, nil
+}
+
+// readBinaryFooter reads the footer after its mark
+func readBinaryFooter(r *bufio.Reader) (*Footer, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > maxBinaryHeader {
+		return nil, fmt.Errorf("invalid footer length")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("truncated footer: %w", err)
+	}
+	var footer Footer
+	if err := json.Unmarshal(data, &footer); err != nil {
+		return nil, fmt.Errorf("invalid footer: %w", err)
+	}
+	return &footer, nil
+}
+
+// maxErrorClass bounds the error class length read from a binary log
+const maxErrorClass = 1 << 10
+
+// fieldReader reads the fields of a record, keeping the first error
+type fieldReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (f *fieldReader) varint() int64 {
+	if f.err != nil {
+		return 0
+	}
+	var v int64
+	v, f.err = binary.ReadVarint(f.r)
+	return v
+}
+
+func (f *fieldReader) uvarint() uint64 {
+	if f.err != nil {
+		return 0
+	}
+	var v uint64
+	v, f.err = binary.ReadUvarint(f.r)
+	return v
+}
+
+func (f *fieldReader) string() string {
+	n := f.uvarint()
+	if f.err != nil || n == 0 {
+		return ""
+	}
+	if n > maxErrorClass {
+		f.err = fmt.Errorf("error class of %d bytes", n)
+		return ""
+	}
+	b := make([]byte, n)
+	_, f.err = io.ReadFull(f.r, b)
+	return string(b)
+}
@@ -0,0 +1,106 @@
+package requestlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// jsonRecord is a record as a line of JSON. Times are Unix nanoseconds and
+// the endpoint is named, so lines can be analysed without the header. Names
+// need not be unique, so the endpoint's index is what is read back.
+type jsonRecord struct {
+	Intended      int64   `json:"intended_unix_ns"`
+	Start         int64   `json:"start_unix_ns"`
+	Latency       int64   `json:"latency_ns"`
+	Endpoint      string  `json:"endpoint"`
+	EndpointIndex *int    `json:"endpoint_index"` // missing from older logs
+	Status        int     `json:"status"`
+	Error         string  `json:"error,omitempty"`
+	BytesOut      int64   `json:"bytes_out"`
+	BytesIn       int64   `json:"bytes_in"`
+	Worker        int     `json:"worker"`
+	Footer        *Footer `json:"footer,omitempty"` // set only on the footer line, which is not a record
+}
+
+// jsonFooter is the last line of a log
+type jsonFooter struct {
+	Footer *Footer `json:"footer"`
+}
+
+// newJSONEncoder writes the header as the first line and returns a function
+// that writes each record as a line
+func newJSONEncoder(w *bufio.Writer, header Header) (func(*bufio.Writer, *Record) error, error) {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return func(w *bufio.Writer, r *Record) error {
+		endpoint := r.Endpoint
+		return enc.Encode(jsonRecord{
+			Intended:      r.Intended.UnixNano(),
+			Start:         r.Start.UnixNano(),
+			Latency:       int64(r.Latency),
+			Endpoint:      header.Endpoints[r.Endpoint],
+			EndpointIndex: &endpoint,
+			Status:        r.Status,
+			Error:         r.Error,
+			BytesOut:      r.BytesOut,
+			BytesIn:       r.BytesIn,
+			Worker:        r.Worker,
+		})
+	}, nil
+}
+
+// encodeJSONFooter writes the footer as the last line
+func encodeJSONFooter(w *bufio.Writer, footer *Footer) error {
+	return json.NewEncoder(w).Encode(jsonFooter{Footer: footer})
+}
+
+// newJSONDecoder reads the header line and returns a function that reads
+// each record line, or the footer line
+func newJSONDecoder(r *bufio.Reader) (Header, func(*bufio.Reader) (Record, *Footer, error), error) {
+	dec := json.NewDecoder(r)
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return Header{}, nil, fmt.Errorf("invalid header: %w", err)
+	}
+	// Logs without endpoint indexes are mapped back by name
+	endpoints := make(map[string]int, len(header.Endpoints))
+	for i, name := range header.Endpoints {
+		if _, ok := endpoints[name]; !ok {
+			endpoints[name] = i
+		}
+	}
+
+	return header, func(*bufio.Reader) (Record, *Footer, error) {
+		var jr jsonRecord
+		if err := dec.Decode(&jr); err != nil {
+			return Record{}, nil, err
+		}
+		if jr.Footer != nil {
+			return Record{}, jr.Footer, nil
+		}
+		var endpoint int
+		if jr.EndpointIndex != nil {
+			endpoint = *jr.EndpointIndex
+		} else {
+			var ok bool
+			if endpoint, ok = endpoints[jr.Endpoint]; !ok {
+				return Record{}, nil, fmt.Errorf("unknown endpoint %q", jr.Endpoint)
+			}
+		}
+		return Record{
+			Intended: time.Unix(0, jr.Intended),
+			Start:    time.Unix(0, jr.Start),
+			Latency:  time.Duration(jr.Latency),
+			Endpoint: endpoint,
+			Status:   jr.Status,
+			Error:    jr.Error,
+			BytesOut: jr.BytesOut,
+			BytesIn:  jr.BytesIn,
+			Worker:   jr.Worker,
+		}, nil, nil
+	}, nil
+}
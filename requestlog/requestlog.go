@@ -0,0 +1,230 @@
+// Package requestlog streams one record per request to a file for offline
+// analysis, and reads the records back so results can be rebuilt after a run
+package requestlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// queueSize is how many records may wait to be written before new ones are
+// dropped
+const queueSize = 1 << 16
+
+// bufferSize is the size of the file write buffer
+const bufferSize = 1 << 20
+
+// Header describes the run a log belongs to. It is written once, before the
+// records.
+type Header struct {
+	Started         time.Time     `json:"started"`     // when the log was created, just before the run started
+	Duration        time.Duration `json:"duration_ns"` // planned duration, after the warmup
+	Warmup          time.Duration `json:"warmup_ns"`
+	Workers         int           `json:"workers"`
+	ConfigFile      string        `json:"config_file"`
+	Config          string        `json:"config"`    // contents of the config file
+	Endpoints       []string      `json:"endpoints"` // endpoint names, indexed by Record.Endpoint
+	LateThreshold   time.Duration `json:"late_threshold_ns"`
+	MetricsInterval time.Duration `json:"metrics_interval_ns"`
+}
+
+// Footer records when the run started and stopped, as its results measure
+// them. It is written once, after the records; logs cut short have none.
+type Footer struct {
+	Started time.Time `json:"started"`
+	Stopped time.Time `json:"stopped"`
+}
+
+// Record is the outcome of a single request
+type Record struct {
+	Intended time.Time     // when the schedule called for the request to be sent
+	Start    time.Time     // when it was actually sent
+	Latency  time.Duration // service time, from Start to the end of the response
+	Endpoint int           // index into Header.Endpoints
+	Status   int           // HTTP status code, 0 if no response was received
+	Error    string        // error class of a failed request, empty on success
+	BytesOut int64         // request size, headers included
+	BytesIn  int64         // response size, headers included
+	Worker   int
+}
+
+// End returns when the request finished
+func (r *Record) End() time.Time {
+	return r.Start.Add(r.Latency)
+}
+
+// ResponseTime returns the time from the intended send to the end of the
+// response
+func (r *Record) ResponseTime() time.Duration {
+	return r.End().Sub(r.Intended)
+}
+
+// Writer streams records to a file from a background goroutine, so requests
+// never wait on the disk. Write, Dropped and Close do nothing on a nil Writer.
+type Writer struct {
+	file         *os.File
+	buf          *bufio.Writer
+	encode       func(*bufio.Writer, *Record) error
+	encodeFooter func(*bufio.Writer, *Footer) error
+	footer       *Footer // set by End, written by Close
+	records      chan Record
+	dropped      atomic.Int64
+	done         chan struct{}
+	err          error // first write error, read after done is closed
+}
+
+// Create creates a log at path, in JSON lines for a .jsonl extension or in
+// the compact binary format for .bin, and writes the header
+func Create(path string, header Header) (*Writer, error) {
+	var newEncoder func(*bufio.Writer, Header) (func(*bufio.Writer, *Record) error, error)
+	var encodeFooter func(*bufio.Writer, *Footer) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		newEncoder, encodeFooter = newJSONEncoder, encodeJSONFooter
+	case ".bin":
+		newEncoder, encodeFooter = newBinaryEncoder, encodeBinaryFooter
+	default:
+		return nil, fmt.Errorf("unknown request log format %q, expected .jsonl or .bin", filepath.Ext(path))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", path, err)
+	}
+	buf := bufio.NewWriterSize(file, bufferSize)
+	encode, err := newEncoder(buf, header)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing %s: %w", path, err)
+	}
+
+	w := &Writer{
+		file:         file,
+		buf:          buf,
+		encode:       encode,
+		encodeFooter: encodeFooter,
+		records:      make(chan Record, queueSize),
+		done:         make(chan struct{}),
+	}
+	go w.write()
+	return w, nil
+}
+
+// Write queues a record. Records are dropped and counted rather than slowing
+// the test down if the disk falls behind.
+func (w *Writer) Write(r Record) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.records <- r:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records dropped because the queue was full
+func (w *Writer) Dropped() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.dropped.Load()
+}
+
+// End records when the run started and stopped, to be written as the footer
+// by Close. It must not be called concurrently with Close.
+func (w *Writer) End(started, stopped time.Time) {
+	if w == nil {
+		return
+	}
+	w.footer = &Footer{Started: started, Stopped: stopped}
+}
+
+// Close writes any queued records and the footer, if End was called, and
+// closes the file. It must not be called while records are still being
+// written.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	close(w.records)
+	<-w.done
+	if w.footer != nil && w.err == nil {
+		w.err = w.encodeFooter(w.buf, w.footer)
+	}
+	if err := w.buf.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// write encodes queued records until the queue is closed
+func (w *Writer) write() {
+	defer close(w.done)
+	for r := range w.records {
+		if w.err != nil {
+			continue
+		}
+		w.err = w.encode(w.buf, &r)
+	}
+}
+
+// Reader reads a log back
+type Reader struct {
+	Header Header
+	Footer *Footer // set once Next has reached the footer, nil for a log without one
+	file   *os.File
+	buf    *bufio.Reader
+	decode func(*bufio.Reader) (Record, *Footer, error)
+}
+
+// Open opens a log in either format and reads its header
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request log: %w", err)
+	}
+	buf := bufio.NewReaderSize(file, bufferSize)
+
+	newDecoder := newJSONDecoder
+	if magic, _ := buf.Peek(len(binaryMagic)); bytes.Equal(magic, []byte(binaryMagic)) {
+		newDecoder = newBinaryDecoder
+	}
+	header, decode, err := newDecoder(buf)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error parsing request log %s: %w", path, err)
+	}
+	return &Reader{Header: header, file: file, buf: buf, decode: decode}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (r *Reader) Next() (Record, error) {
+	rec, footer, err := r.decode(r.buf)
+	if footer != nil {
+		r.Footer = footer
+		return Record{}, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return Record{}, fmt.Errorf("error parsing request log: %w", err)
+	}
+	if err == nil && (rec.Endpoint < 0 || rec.Endpoint >= len(r.Header.Endpoints)) {
+		return Record{}, fmt.Errorf("error parsing request log: endpoint %d out of range", rec.Endpoint)
+	}
+	return rec, err
+}
+
+// Close closes the log
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,136 @@
+package requestlog
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecords(started time.Time) []Record {
+	return []Record{
+		{Intended: started, Start: started.Add(time.Millisecond), Latency: 12 * time.Millisecond,
+			Endpoint: 0, Status: 200, BytesOut: 120, BytesIn: 480, Worker: 3},
+		{Intended: started.Add(5 * time.Millisecond), Start: started.Add(5 * time.Millisecond), Latency: 3 * time.Second,
+			Endpoint: 1, Error: "timeout", BytesOut: 90, Worker: 0},
+		// Out of order, as workers finish in any order
+		{Intended: started.Add(2 * time.Millisecond), Start: started.Add(40 * time.Millisecond), Latency: time.Millisecond,
+			Endpoint: 1, Status: 503, Error: "http_status", BytesOut: 90, BytesIn: 60, Worker: 12},
+	}
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	for _, ext := range []string{".jsonl", ".bin"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "requests"+ext)
+			started := time.Unix(1700000000, 123456789)
+			header := Header{
+				Started:         started,
+				Duration:        time.Minute,
+				Workers:         16,
+				Config:          "max_rps: 100\n",
+				Endpoints:       []string{"posts", "posts"}, // names need not be unique
+				MetricsInterval: time.Second,
+			}
+
+			w, err := Create(path, header)
+			if err != nil {
+				t.Fatalf("Create returned error: %v", err)
+			}
+			records := testRecords(started)
+			for _, r := range records {
+				w.Write(r)
+			}
+			stopped := started.Add(time.Minute)
+			w.End(started, stopped)
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close returned error: %v", err)
+			}
+
+			r, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open returned error: %v", err)
+			}
+			defer r.Close()
+
+			if !r.Header.Started.Equal(started) || r.Header.Workers != 16 || len(r.Header.Endpoints) != 2 ||
+				r.Header.Config != header.Config || r.Header.MetricsInterval != time.Second {
+				t.Errorf("Header did not round trip: %+v", r.Header)
+			}
+			for i, want := range records {
+				got, err := r.Next()
+				if err != nil {
+					t.Fatalf("Next returned error on record %d: %v", i, err)
+				}
+				if !got.Intended.Equal(want.Intended) || !got.Start.Equal(want.Start) || got.Latency != want.Latency ||
+					got.Endpoint != want.Endpoint || got.Status != want.Status || got.Error != want.Error ||
+					got.BytesOut != want.BytesOut || got.BytesIn != want.BytesIn || got.Worker != want.Worker {
+					t.Errorf("Record %d did not round trip: got %+v, want %+v", i, got, want)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("Expected io.EOF after the last record, got %v", err)
+			}
+			if r.Footer == nil || !r.Footer.Started.Equal(started) || !r.Footer.Stopped.Equal(stopped) {
+				t.Errorf("Footer did not round trip: %+v", r.Footer)
+			}
+		})
+	}
+}
+
+func TestReader_Truncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.bin")
+	started := time.Now()
+	w, err := Create(path, Header{Started: started, Endpoints: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	for _, r := range testRecords(started) {
+		w.Write(r)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer r.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := r.Next(); err != nil {
+			t.Fatalf("Next returned error on intact record %d: %v", i, err)
+		}
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("Expected an error on the truncated record, got %v", err)
+	}
+	if r.Footer != nil {
+		t.Errorf("Expected no footer in a log cut short, got %+v", r.Footer)
+	}
+}
+
+func TestCreate_UnknownFormat(t *testing.T) {
+	_, err := Create(filepath.Join(t.TempDir(), "requests.csv"), Header{})
+	if err == nil || !strings.Contains(err.Error(), "unknown request log format") {
+		t.Errorf("Expected an unknown format error, got %v", err)
+	}
+}
+
+func TestWriter_Nil(t *testing.T) {
+	var w *Writer
+	w.Write(Record{})
+	if w.Dropped() != 0 || w.Close() != nil {
+		t.Error("Expected a nil writer to do nothing")
+	}
+}
@@ -0,0 +1,222 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"protobuf/config"
+	"protobuf/worker"
+)
+
+// defaultThroughputTolerance is how far achieved RPS may fall short of the
+// target when the SLO does not set a tolerance
+const defaultThroughputTolerance = 0.05
+
+// Level is the outcome of holding a single rate
+type Level struct {
+	TargetRPS   int
+	AchievedRPS float64
+	Requests    int64
+	ErrorRate   float64
+	P50         time.Duration
+	P95         time.Duration
+	P99         time.Duration
+	Passed      bool
+	Reason      string // why the level failed
+}
+
+// Result is the outcome of a capacity search
+type Result struct {
+	MaxRPS int     // highest rate that met the SLO, or 0 if none did
+	Levels []Level // every level that was held, ordered by target rate
+}
+
+// Runner finds the maximum sustainable rate by driving a worker pool at
+// increasing rates until the SLO is breached
+type Runner struct {
+	workers int
+	config  *config.Config
+	// OnLevel, if set, is called after each level completes
+	OnLevel func(Level)
+}
+
+// NewRunner creates a capacity search runner for the given config. The
+// endpoints and arrival settings are taken from cfg; the load pattern is
+// replaced by a constant rate for each level.
+func NewRunner(workers int, cfg *config.Config) *Runner {
+	return &Runner{
+		workers: workers,
+		config:  cfg,
+	}
+}
+
+// Run performs the search. It returns the levels held so far if the context
+// is cancelled.
+func (r *Runner) Run(ctx context.Context) (*Result, error) {
+	if err := r.config.Search.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid search config: %w", err)
+	}
+
+	result := &Result{}
+	var err error
+	if r.config.Search.Strategy == "step" {
+		err = r.stepUp(ctx, result)
+	} else {
+		err = r.binary(ctx, result)
+	}
+
+	sort.Slice(result.Levels, func(i, j int) bool {
+		return result.Levels[i].TargetRPS < result.Levels[j].TargetRPS
+	})
+	return result, err
+}
+
+// stepUp raises the rate by a fixed step until a level fails
+func (r *Runner) stepUp(ctx context.Context, result *Result) error {
+	s := r.config.Search
+	step := s.Step
+	if step <= 0 {
+		step = defaultStep(s)
+	}
+
+	for rps := s.MinRPS; rps <= s.MaxRPS; rps += step {
+		level, err := r.hold(ctx, rps, result)
+		if err != nil {
+			return err
+		}
+		if !level.Passed {
+			return nil
+		}
+		result.MaxRPS = rps
+	}
+	return nil
+}
+
+// binary bisects the range between the lowest and highest rate until it is
+// narrower than the step
+func (r *Runner) binary(ctx context.Context, result *Result) error {
+	s := r.config.Search
+	precision := s.Step
+	if precision <= 0 {
+		precision = defaultStep(s)
+	}
+
+	level, err := r.hold(ctx, s.MinRPS, result)
+	if err != nil || !level.Passed {
+		return err
+	}
+	result.MaxRPS = s.MinRPS
+
+	level, err = r.hold(ctx, s.MaxRPS, result)
+	if err != nil {
+		return err
+	}
+	if level.Passed {
+		result.MaxRPS = s.MaxRPS
+		return nil
+	}
+
+	low, high := s.MinRPS, s.MaxRPS
+	for high-low > precision {
+		mid := low + (high-low)/2
+		level, err := r.hold(ctx, mid, result)
+		if err != nil {
+			return err
+		}
+		if level.Passed {
+			low = mid
+			result.MaxRPS = mid
+		} else {
+			high = mid
+		}
+	}
+	return nil
+}
+
+// hold drives the target at a constant rate for the hold duration and
+// evaluates the result against the SLO
+func (r *Runner) hold(ctx context.Context, rps int, result *Result) (Level, error) {
+	if err := ctx.Err(); err != nil {
+		return Level{}, err
+	}
+
+	cfg := *r.config
+	cfg.LoadPattern = config.LoadPattern{
+		Type:      "constant",
+		StartRPS:  rps,
+		Arrival:   r.config.LoadPattern.Arrival,
+		BurstSize: r.config.LoadPattern.BurstSize,
+	}
+	cfg.MaxRPS = rps
+	cfg.Duration = r.config.Search.Hold
+	// Only the first level warms the target up
+	if len(result.Levels) > 0 {
+		cfg.Warmup = config.Warmup{}
+	}
+
+	pool := worker.NewPool(r.workers, &cfg)
+	levelCtx, cancel := context.WithTimeout(ctx, cfg.Warmup.Duration+cfg.Duration)
+	defer cancel()
+
+	pool.Start(levelCtx)
+	<-levelCtx.Done()
+	pool.Stop()
+
+	if err := ctx.Err(); err != nil {
+		return Level{}, err
+	}
+
+	level := r.evaluate(rps, pool.GetMetrics())
+	result.Levels = append(result.Levels, level)
+	if r.OnLevel != nil {
+		r.OnLevel(level)
+	}
+	return level, nil
+}
+
+// evaluate checks the metrics of a level against the SLO. The achieved rate is
+// the pool's own, which leaves out the warmup and the drain at the end.
+func (r *Runner) evaluate(rps int, metrics *config.Metrics) Level {
+	slo := r.config.Search.SLO
+	level := Level{
+		TargetRPS:   rps,
+		AchievedRPS: metrics.AchievedRPS,
+		Requests:    metrics.TotalRequests,
+		P50:         metrics.ResponseTimeStats.P50,
+		P95:         metrics.ResponseTimeStats.P95,
+		P99:         metrics.ResponseTimeStats.P99,
+		Passed:      true,
+	}
+	if metrics.TotalRequests > 0 {
+		level.ErrorRate = float64(metrics.FailedRequests) / float64(metrics.TotalRequests)
+	}
+
+	tolerance := slo.ThroughputTolerance
+	if tolerance <= 0 {
+		tolerance = defaultThroughputTolerance
+	}
+
+	switch {
+	case slo.ErrorRate > 0 && level.ErrorRate > slo.ErrorRate:
+		level.Passed = false
+		level.Reason = fmt.Sprintf("error rate %.2f%% exceeds %.2f%%", level.ErrorRate*100, slo.ErrorRate*100)
+	case slo.P99 > 0 && level.P99 > slo.P99:
+		level.Passed = false
+		level.Reason = fmt.Sprintf("p99 %v exceeds %v", level.P99, slo.P99)
+	case level.AchievedRPS < float64(rps)*(1-tolerance):
+		level.Passed = false
+		level.Reason = fmt.Sprintf("achieved %.1f RPS of %d", level.AchievedRPS, rps)
+	}
+	return level
+}
+
+// defaultStep divides the search range into twenty steps
+func defaultStep(s config.Search) int {
+	step := (s.MaxRPS - s.MinRPS) / 20
+	if step < 1 {
+		step = 1
+	}
+	return step
+}
@@ -0,0 +1,117 @@
+package search
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+// newCapacityServer starts a server that fails requests beyond rps per second
+func newCapacityServer(t *testing.T, rps int) string {
+	t.Helper()
+
+	var mu sync.Mutex
+	var window time.Time
+	var count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		now := time.Now()
+		if now.Sub(window) >= 100*time.Millisecond {
+			window = now
+			count = 0
+		}
+		count++
+		over := count > rps/10
+		mu.Unlock()
+
+		if over {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestRunner_FindsCapacity(t *testing.T) {
+	tests := []struct {
+		strategy string
+		step     int
+	}{
+		{"binary", 10},
+		{"step", 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			cfg := &config.Config{
+				Endpoints: []config.Endpoint{{URL: newCapacityServer(t, 200), Method: "GET"}},
+				Search: config.Search{
+					Strategy: tt.strategy,
+					MinRPS:   40,
+					MaxRPS:   400,
+					Step:     tt.step,
+					Hold:     time.Second,
+					SLO:      config.SLO{ErrorRate: 0.01},
+				},
+			}
+
+			result, err := NewRunner(20, cfg).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run returned error: %v", err)
+			}
+
+			if result.MaxRPS < 150 || result.MaxRPS > 200 {
+				t.Errorf("Expected maximum RPS near the server capacity of 200, got %d", result.MaxRPS)
+			}
+
+			for i := 1; i < len(result.Levels); i++ {
+				if result.Levels[i].TargetRPS < result.Levels[i-1].TargetRPS {
+					t.Fatal("Expected levels ordered by target rate")
+				}
+			}
+			last := result.Levels[len(result.Levels)-1]
+			if last.Passed && last.TargetRPS != cfg.Search.MaxRPS {
+				t.Error("Expected the search to stop at a failing level")
+			}
+		})
+	}
+}
+
+func TestRunner_ThroughputExcludesDrain(t *testing.T) {
+	// Every level ends with requests in flight for most of a second, which
+	// must not count against the rate achieved
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(800 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{{URL: server.URL, Method: "GET"}},
+		Search: config.Search{
+			Strategy: "step",
+			MinRPS:   20,
+			MaxRPS:   40,
+			Step:     20,
+			Hold:     2 * time.Second,
+			SLO:      config.SLO{P99: 2 * time.Second},
+		},
+	}
+
+	result, err := NewRunner(50, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	for _, level := range result.Levels {
+		if !level.Passed {
+			t.Errorf("Expected %d RPS to pass, failed: %s", level.TargetRPS, level.Reason)
+		}
+	}
+	if result.MaxRPS != 40 {
+		t.Errorf("Expected the search to reach 40 RPS, got %d", result.MaxRPS)
+	}
+}
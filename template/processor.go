@@ -18,48 +18,65 @@ func init() {
 
 // Processor handles template processing for request data
 type Processor struct {
-	functions template.FuncMap
+	functions  template.FuncMap
+	partition  int // index of the partition of the test data this process reads
+	partitions int // number of partitions, 1 when the data is not split
 }
 
 // NewProcessor creates a new template processor
 func NewProcessor() *Processor {
-	return &Processor{
-		functions: template.FuncMap{
-			"randomInt": func(min, max int) int {
-				return rand.Intn(max-min+1) + min
-			},
-			"randomUUID": func() string {
-				return fmt.Sprintf("%x-%x-%x-%x-%x",
-					rand.Uint32(),
-					uint16(rand.Uint32()),
-					uint16(rand.Uint32()),
-					uint16(rand.Uint32()),
-					rand.Uint64())
-			},
-			"timestamp": func() string {
-				return time.Now().Format(time.RFC3339)
-			},
-			"readCSV": func(filename string) string {
-				file, err := os.Open(filename)
-				if err != nil {
-					return ""
-				}
-				defer file.Close()
+	p := &Processor{partitions: 1}
+	p.functions = template.FuncMap{
+		"randomInt": func(min, max int) int {
+			return rand.Intn(max-min+1) + min
+		},
+		"randomUUID": func() string {
+			return fmt.Sprintf("%x-%x-%x-%x-%x",
+				rand.Uint32(),
+				uint16(rand.Uint32()),
+				uint16(rand.Uint32()),
+				uint16(rand.Uint32()),
+				rand.Uint64())
+		},
+		"timestamp": func() string {
+			return time.Now().Format(time.RFC3339)
+		},
+		"readCSV": func(filename string) string {
+			file, err := os.Open(filename)
+			if err != nil {
+				return ""
+			}
+			defer file.Close()
 
-				reader := csv.NewReader(file)
-				records, err := reader.ReadAll()
-				if err != nil || len(records) == 0 {
-					return ""
-				}
+			reader := csv.NewReader(file)
+			records, err := reader.ReadAll()
+			if err != nil || len(records) == 0 {
+				return ""
+			}
 
-				// Return a random row from the CSV
-				return records[rand.Intn(len(records))][0]
-			},
-			"env": func(key string) string {
-				return os.Getenv(key)
-			},
+			// Return a random row from this process's partition of the CSV
+			if len(records) <= p.partition {
+				return ""
+			}
+			rows := (len(records) - p.partition + p.partitions - 1) / p.partitions
+			return records[p.partition+rand.Intn(rows)*p.partitions][0]
+		},
+		"env": func(key string) string {
+			return os.Getenv(key)
 		},
+		"partition":  func() int { return p.partition },
+		"partitions": func() int { return p.partitions },
+	}
+	return p
+}
+
+// SetPartition makes readCSV read only partition index of count, dealing
+// rows out round robin, so processes splitting a test send different data
+func (p *Processor) SetPartition(index, count int) {
+	if count < 1 {
+		index, count = 0, 1
 	}
+	p.partition, p.partitions = index, count
 }
 
 // ProcessTemplate processes a template string with the given data
@@ -0,0 +1,341 @@
+// Package threshold checks test results against configured limits such as
+// "p99 < 250ms", so a run can pass or fail a CI pipeline
+package threshold
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"protobuf/config"
+	"protobuf/report"
+)
+
+// defaultAbortAfter is how long a test runs before an abort threshold may
+// stop it, when the threshold does not set abort_after
+const defaultAbortAfter = 10 * time.Second
+
+// watchInterval is how often abort thresholds are checked during a run
+const watchInterval = time.Second
+
+// metricKinds maps each metric a threshold may limit to the kind of value it
+// takes
+var metricKinds = map[string]kind{
+	"p50":        duration,
+	"p95":        duration,
+	"p99":        duration,
+	"p999":       duration,
+	"max":        duration,
+	"mean":       duration,
+	"error_rate": fraction,
+	"rps":        number,
+	"requests":   number,
+}
+
+// kind is the type of value a metric is limited by
+type kind int
+
+const (
+	duration kind = iota // e.g. 250ms
+	fraction             // e.g. 0.5% or 0.005
+	number               // e.g. 900
+)
+
+// Threshold is a parsed limit
+type Threshold struct {
+	config.Threshold
+	metric string
+	op     string
+	limit  float64 // nanoseconds for durations
+}
+
+// Parse parses a threshold expression of the form "<metric> <op> <value>",
+// where op is one of <, <=, > or >=
+func Parse(t config.Threshold) (*Threshold, error) {
+	fields := strings.Fields(t.Expr)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("threshold %q: expected <metric> <op> <value>", t.Expr)
+	}
+	metric, op, value := fields[0], fields[1], fields[2]
+
+	k, ok := metricKinds[metric]
+	if !ok {
+		return nil, fmt.Errorf("threshold %q: unknown metric %s", t.Expr, metric)
+	}
+	switch op {
+	case "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("threshold %q: unknown operator %s", t.Expr, op)
+	}
+	if t.Endpoint != "" && t.Stage != "" {
+		return nil, fmt.Errorf("threshold %q: set an endpoint or a stage, not both", t.Expr)
+	}
+	if t.Stage != "" && (metric == "p999" || metric == "mean") {
+		return nil, fmt.Errorf("threshold %q: %s is not kept per stage", t.Expr, metric)
+	}
+	// Counts and rates build up over the run, so a partial run would fail
+	// them before they could be reached
+	if t.Abort && k == number {
+		return nil, fmt.Errorf("threshold %q: only latency and error_rate thresholds can abort", t.Expr)
+	}
+
+	limit, err := parseValue(k, value)
+	if err != nil {
+		return nil, fmt.Errorf("threshold %q: %w", t.Expr, err)
+	}
+	if t.AbortAfter <= 0 {
+		t.AbortAfter = defaultAbortAfter
+	}
+	return &Threshold{Threshold: t, metric: metric, op: op, limit: limit}, nil
+}
+
+// parseValue parses a limit of the given kind
+func parseValue(k kind, value string) (float64, error) {
+	switch k {
+	case duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", value)
+		}
+		return float64(d), nil
+	case fraction:
+		if strings.HasSuffix(value, "%") {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid percentage %s", value)
+			}
+			return v / 100, nil
+		}
+		fallthrough
+	default:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %s", value)
+		}
+		return v, nil
+	}
+}
+
+// Name describes the threshold and what it applies to
+func (t *Threshold) Name() string {
+	switch {
+	case t.Endpoint != "":
+		return fmt.Sprintf("%s [endpoint %s]", t.Expr, t.Endpoint)
+	case t.Stage != "":
+		return fmt.Sprintf("%s [stage %s]", t.Expr, t.Stage)
+	default:
+		return t.Expr
+	}
+}
+
+// Check evaluates the threshold against the metrics
+func (t *Threshold) Check(m *config.Metrics) report.Check {
+	v, err := t.observe(m)
+	if err != nil {
+		return report.Check{Name: t.Name(), Passed: false, Message: err.Error()}
+	}
+
+	var passed bool
+	switch t.op {
+	case "<":
+		passed = v < t.limit
+	case "<=":
+		passed = v <= t.limit
+	case ">":
+		passed = v > t.limit
+	case ">=":
+		passed = v >= t.limit
+	}
+	return report.Check{
+		Name:    t.Name(),
+		Passed:  passed,
+		Message: fmt.Sprintf("%s was %s", t.metric, t.format(v)),
+	}
+}
+
+// observe returns the value of the threshold's metric over what it applies to
+func (t *Threshold) observe(m *config.Metrics) (float64, error) {
+	switch {
+	case t.Endpoint != "":
+		for _, e := range m.Endpoints {
+			if e.Name == t.Endpoint {
+				return t.value(e.ResponseTimeStats, e.TotalRequests, e.FailedRequests, e.RPS), nil
+			}
+		}
+		return 0, fmt.Errorf("no endpoint named %s", t.Endpoint)
+	case t.Stage != "":
+		return t.stageValue(m.TimeSeries)
+	default:
+		return t.value(m.ResponseTimeStats, m.TotalRequests, m.FailedRequests, m.AchievedRPS), nil
+	}
+}
+
+// value picks the threshold's metric out of a set of results
+func (t *Threshold) value(s config.LatencyStats, requests, failed int64, rps float64) float64 {
+	switch t.metric {
+	case "p50":
+		return float64(s.P50)
+	case "p95":
+		return float64(s.P95)
+	case "p99":
+		return float64(s.P99)
+	case "p999":
+		return float64(s.P999)
+	case "max":
+		return float64(s.Max)
+	case "mean":
+		return float64(s.Mean)
+	case "error_rate":
+		if requests == 0 {
+			return 0
+		}
+		return float64(failed) / float64(requests)
+	case "rps":
+		return rps
+	default: // requests
+		return float64(requests)
+	}
+}
+
+// stageValue derives the metric for one load stage from the time series.
+// Only per-interval percentiles are kept, so a stage's percentile is the
+// highest of its intervals, which errs on the side of failing.
+func (t *Threshold) stageValue(series []config.IntervalMetrics) (float64, error) {
+	var requests, failed int64
+	var elapsed time.Duration
+	var worst config.IntervalMetrics
+	found := false
+	for _, i := range series {
+		if i.Stage != t.Stage {
+			continue
+		}
+		found = true
+		requests += i.Requests
+		failed += i.Failed
+		elapsed += i.Duration
+		if i.P50 > worst.P50 {
+			worst.P50 = i.P50
+		}
+		if i.P95 > worst.P95 {
+			worst.P95 = i.P95
+		}
+		if i.P99 > worst.P99 {
+			worst.P99 = i.P99
+		}
+		if i.Max > worst.Max {
+			worst.Max = i.Max
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("stage %s never ran", t.Stage)
+	}
+
+	var rps float64
+	if elapsed > 0 {
+		rps = float64(requests) / elapsed.Seconds()
+	}
+	stats := config.LatencyStats{P50: worst.P50, P95: worst.P95, P99: worst.P99, Max: worst.Max}
+	return t.value(stats, requests, failed, rps), nil
+}
+
+// format formats an observed value the way the limit was written
+func (t *Threshold) format(v float64) string {
+	switch metricKinds[t.metric] {
+	case duration:
+		return time.Duration(v).Round(time.Microsecond).String()
+	case fraction:
+		return fmt.Sprintf("%.2f%%", 100*v)
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// Set is the thresholds of a run
+type Set []*Threshold
+
+// New parses the thresholds of cfg, checking the endpoints and stages they
+// name exist
+func New(cfg *config.Config) (Set, error) {
+	set := make(Set, 0, len(cfg.Thresholds))
+	for _, t := range cfg.Thresholds {
+		parsed, err := Parse(t)
+		if err != nil {
+			return nil, err
+		}
+		if t.Endpoint != "" && !hasEndpoint(cfg.Endpoints, t.Endpoint) {
+			return nil, fmt.Errorf("threshold %q: no endpoint named %s", t.Expr, t.Endpoint)
+		}
+		if t.Stage != "" && !cfg.HasStage(t.Stage) {
+			return nil, fmt.Errorf("threshold %q: the load pattern has no stage %s", t.Expr, t.Stage)
+		}
+		set = append(set, parsed)
+	}
+	return set, nil
+}
+
+// hasEndpoint reports whether an endpoint goes by the name
+func hasEndpoint(endpoints []config.Endpoint, name string) bool {
+	for _, e := range endpoints {
+		if e.DisplayName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check evaluates every threshold against the final metrics of a run
+func (s Set) Check(m *config.Metrics) []report.Check {
+	checks := make([]report.Check, len(s))
+	for i, t := range s {
+		checks[i] = t.Check(m)
+	}
+	return checks
+}
+
+// Watch checks the abort thresholds against live metrics every second until
+// ctx is done, calling abort with the first one that fails. A stage threshold
+// is only checked once its stage has started.
+func (s Set) Watch(ctx context.Context, source func() *config.Metrics, abort func(report.Check)) {
+	var watched Set
+	for _, t := range s {
+		if t.Abort {
+			watched = append(watched, t)
+		}
+	}
+	if len(watched) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m := source()
+			for _, t := range watched {
+				if m.Elapsed < t.AbortAfter || (t.Stage != "" && !hasStage(m.TimeSeries, t.Stage)) {
+					continue
+				}
+				if check := t.Check(m); !check.Passed {
+					abort(check)
+					return
+				}
+			}
+		}
+	}
+}
+
+// hasStage reports whether the time series has an interval in the stage
+func hasStage(series []config.IntervalMetrics, stage string) bool {
+	for _, i := range series {
+		if i.Stage == stage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package threshold
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"protobuf/config"
+	"protobuf/report"
+)
+
+func testMetrics() *config.Metrics {
+	return &config.Metrics{
+		TotalRequests:     1000,
+		FailedRequests:    4,
+		AchievedRPS:       950,
+		Elapsed:           20 * time.Second,
+		ResponseTimeStats: config.LatencyStats{P50: 20 * time.Millisecond, P95: 120 * time.Millisecond, P99: 240 * time.Millisecond},
+		Endpoints: []config.EndpointMetrics{
+			{Name: "search", TotalRequests: 400, FailedRequests: 4, RPS: 380,
+				ResponseTimeStats: config.LatencyStats{P99: 400 * time.Millisecond}},
+		},
+		TimeSeries: []config.IntervalMetrics{
+			{Stage: "ramp-up step 1", Duration: time.Second, Requests: 500, P99: 100 * time.Millisecond},
+			{Stage: "ramp-up step 2", Duration: time.Second, Requests: 900, Failed: 9, P99: 300 * time.Millisecond},
+			{Stage: "ramp-up step 2", Duration: time.Second, Requests: 900, P99: 200 * time.Millisecond},
+		},
+	}
+}
+
+func TestThreshold_Check(t *testing.T) {
+	tests := []struct {
+		threshold config.Threshold
+		passed    bool
+		message   string
+	}{
+		{config.Threshold{Expr: "p99 < 250ms"}, true, "p99 was 240ms"},
+		{config.Threshold{Expr: "p95 <= 100ms"}, false, "p95 was 120ms"},
+		{config.Threshold{Expr: "error_rate < 0.5%"}, true, "error_rate was 0.40%"},
+		{config.Threshold{Expr: "error_rate < 0.001"}, false, "error_rate was 0.40%"},
+		{config.Threshold{Expr: "rps >= 900"}, true, "rps was 950"},
+		{config.Threshold{Expr: "p99 < 250ms", Endpoint: "search"}, false, "p99 was 400ms"},
+		{config.Threshold{Expr: "error_rate < 0.5%", Endpoint: "search"}, false, "error_rate was 1.00%"},
+		{config.Threshold{Expr: "p99 < 250ms", Stage: "ramp-up step 1"}, true, "p99 was 100ms"},
+		{config.Threshold{Expr: "p99 < 250ms", Stage: "ramp-up step 2"}, false, "p99 was 300ms"},
+		{config.Threshold{Expr: "rps >= 900", Stage: "ramp-up step 2"}, true, "rps was 900"},
+		{config.Threshold{Expr: "p99 < 250ms", Endpoint: "missing"}, false, "no endpoint named missing"},
+		{config.Threshold{Expr: "p99 < 250ms", Stage: "ramp-up step 9"}, false, "stage ramp-up step 9 never ran"},
+	}
+
+	m := testMetrics()
+	for _, tt := range tests {
+		th, err := Parse(tt.threshold)
+		if err != nil {
+			t.Fatalf("Parse(%+v) returned error: %v", tt.threshold, err)
+		}
+		check := th.Check(m)
+		if check.Passed != tt.passed || check.Message != tt.message {
+			t.Errorf("%s: got passed=%v %q, want passed=%v %q",
+				th.Name(), check.Passed, check.Message, tt.passed, tt.message)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, th := range []config.Threshold{
+		{Expr: "p99<250ms"},
+		{Expr: "p42 < 250ms"},
+		{Expr: "p99 != 250ms"},
+		{Expr: "p99 < fast"},
+		{Expr: "error_rate < lots%"},
+		{Expr: "p99 < 250ms", Endpoint: "search", Stage: "ramp-up step 1"},
+		{Expr: "mean < 250ms", Stage: "ramp-up step 1"},
+		{Expr: "requests >= 100000", Abort: true},
+		{Expr: "rps >= 900", Abort: true},
+	} {
+		if _, err := Parse(th); err == nil {
+			t.Errorf("Parse(%+v) succeeded, want an error", th)
+		}
+	}
+}
+
+func TestSet_WatchAborts(t *testing.T) {
+	set, err := New(&config.Config{Thresholds: []config.Threshold{
+		{Expr: "p99 < 1s", Abort: true, AbortAfter: time.Second},
+		{Expr: "p95 < 100ms"}, // failing, but not an abort threshold
+		{Expr: "error_rate < 0.1%", Abort: true, AbortAfter: 10 * time.Second},
+	}})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	source := func() *config.Metrics {
+		m := testMetrics()
+		m.Elapsed = 10*time.Second + time.Since(start)
+		return m
+	}
+
+	var aborted []report.Check
+	set.Watch(ctx, source, func(c report.Check) { aborted = append(aborted, c) })
+
+	if len(aborted) != 1 || aborted[0].Name != "error_rate < 0.1%" {
+		t.Fatalf("Expected an abort on the error rate threshold, got %+v", aborted)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected the abort on the first check, took %v", time.Since(start))
+	}
+}
+
+func TestNew_ChecksNames(t *testing.T) {
+	cfg := &config.Config{
+		Endpoints:   []config.Endpoint{{Name: "search"}, {Method: "GET", URL: "http://localhost/health"}},
+		LoadPattern: config.LoadPattern{Type: "ramp-up"},
+		Warmup:      config.Warmup{Duration: time.Minute},
+	}
+	for _, th := range []config.Threshold{
+		{Expr: "p99 < 250ms", Endpoint: "search"},
+		{Expr: "p99 < 250ms", Endpoint: "GET http://localhost/health"},
+		{Expr: "p99 < 250ms", Stage: "ramp-up step 3"},
+		{Expr: "p99 < 250ms", Stage: "warmup"},
+	} {
+		cfg.Thresholds = []config.Threshold{th}
+		if _, err := New(cfg); err != nil {
+			t.Errorf("New(%+v) returned error: %v", th, err)
+		}
+	}
+
+	for _, th := range []config.Threshold{
+		{Expr: "p99 < 250ms", Endpoint: "serach"},
+		{Expr: "p99 < 250ms", Stage: "ramp up step 3"},
+		{Expr: "p99 < 250ms", Stage: "ramp-up step 0"},
+		{Expr: "p99 < 250ms", Stage: "constant"},
+	} {
+		cfg.Thresholds = []config.Threshold{th}
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded, want an error", th)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	exportQueueSize = 8192            // spans waiting for export
+	exportBatchSize = 512             // spans per export request
+	exportInterval  = time.Second     // longest a span waits in a partial batch
+	exportTimeout   = 5 * time.Second // per request to a collector
+)
+
+// OTLP span kind and status codes
+const (
+	spanKindClient  = 3
+	statusCodeOK    = 1
+	statusCodeError = 2
+)
+
+// exporter writes batches of spans
+type exporter interface {
+	Export(spans []Span) error
+	Close() error
+}
+
+// fileExporter appends each batch to a file as a line of OTLP/JSON, the format
+// the OpenTelemetry collector's file exporter and receiver use
+type fileExporter struct {
+	file        *os.File
+	w           *bufio.Writer
+	serviceName string
+}
+
+// newFileExporter creates or truncates the span file
+func newFileExporter(path, serviceName string) (*fileExporter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace file: %w", err)
+	}
+	return &fileExporter{file: file, w: bufio.NewWriter(file), serviceName: serviceName}, nil
+}
+
+// Export writes a batch as a single line
+func (e *fileExporter) Export(spans []Span) error {
+	if err := json.NewEncoder(e.w).Encode(encodeSpans(spans, e.serviceName)); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// Close closes the span file
+func (e *fileExporter) Close() error {
+	if err := e.w.Flush(); err != nil {
+		e.file.Close()
+		return err
+	}
+	return e.file.Close()
+}
+
+// httpExporter posts each batch to an OTLP/HTTP collector as JSON
+type httpExporter struct {
+	endpoint    string
+	client      *http.Client
+	serviceName string
+}
+
+// newHTTPExporter creates an exporter for a collector's traces endpoint, e.g.
+// http://localhost:4318/v1/traces
+func newHTTPExporter(endpoint, serviceName string) *httpExporter {
+	return &httpExporter{
+		endpoint:    endpoint,
+		client:      &http.Client{Timeout: exportTimeout},
+		serviceName: serviceName,
+	}
+}
+
+// Export posts a batch to the collector
+func (e *httpExporter) Export(spans []Span) error {
+	body, err := json.Marshal(encodeSpans(spans, e.serviceName))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close does nothing; the collector needs no goodbye
+func (e *httpExporter) Close() error {
+	return nil
+}
+
+// The OTLP/JSON encoding of a batch of spans. Only the fields the tool sets are
+// included; IDs are hex and timestamps are decimal strings, as the protocol's
+// JSON mapping requires.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+// encodeSpans converts spans to their OTLP/JSON form
+func encodeSpans(spans []Span, serviceName string) otlpTraces {
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			Name:              span.Name,
+			Kind:              spanKindClient,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.Attributes),
+			Status:            otlpStatus{Code: statusCodeOK},
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: statusCodeError, Message: span.Error}
+		}
+		encoded[i] = s
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes(map[string]interface{}{
+			"service.name": serviceName,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: defaultServiceName},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+// encodeAttributes converts attributes to OTLP key/value pairs in key order
+func encodeAttributes(attrs map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	encoded := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		var v otlpValue
+		switch value := attrs[k].(type) {
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		encoded = append(encoded, otlpAttribute{Key: k, Value: v})
+	}
+	return encoded
+}
@@ -0,0 +1,184 @@
+// Package tracing propagates W3C trace context on outgoing requests and
+// exports client spans for sampled requests over OTLP, so load test requests
+// can be found among the target's own traces
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"protobuf/config"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// defaultServiceName is the service spans are reported under, when the config
+// does not set tracing.service_name
+const defaultServiceName = "stress-test"
+
+// SpanContext identifies a request's span within its trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Traceparent formats the span context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// TraceIDString returns the trace ID in hex, as trace backends display it
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Span is a finished client span
+type Span struct {
+	Context    SpanContext
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{} // string or int64 values
+	Error      string                 // status message of a failed request, empty on success
+}
+
+// Tracer starts a trace for every request and exports the spans of the sampled
+// ones. Finish, Dropped and Close do nothing on a nil Tracer.
+type Tracer struct {
+	ratio    float64
+	exporter exporter
+	spans    chan Span
+	dropped  atomic.Int64
+	done     chan struct{}
+	mu       sync.Mutex // guards rand
+	rand     *rand.Rand
+}
+
+// NewTracer creates a tracer from the tracing config, or returns nil if
+// tracing is disabled
+func NewTracer(cfg config.Tracing) (*Tracer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	var exp exporter
+	var err error
+	switch {
+	case cfg.File != "":
+		exp, err = newFileExporter(cfg.File, serviceName)
+	case cfg.Endpoint != "":
+		exp = newHTTPExporter(cfg.Endpoint, serviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tracer{
+		ratio:    cfg.SampleRatio,
+		exporter: exp,
+		spans:    make(chan Span, exportQueueSize),
+		done:     make(chan struct{}),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go t.export()
+	return t, nil
+}
+
+// Start returns the span context for a new request, sampled at the configured
+// ratio
+func (t *Tracer) Start() SpanContext {
+	var sc SpanContext
+	t.mu.Lock()
+	binary.BigEndian.PutUint64(sc.TraceID[:8], t.rand.Uint64())
+	binary.BigEndian.PutUint64(sc.TraceID[8:], t.rand.Uint64())
+	binary.BigEndian.PutUint64(sc.SpanID[:], t.rand.Uint64())
+	sc.Sampled = t.rand.Float64() < t.ratio
+	t.mu.Unlock()
+	return sc
+}
+
+// Finish queues the span of a sampled request for export. Spans are dropped
+// and counted rather than slowing the test down if the exporter falls behind.
+func (t *Tracer) Finish(span Span) {
+	if t == nil || t.exporter == nil || !span.Context.Sampled {
+		return
+	}
+	select {
+	case t.spans <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of spans dropped because the export queue was full
+func (t *Tracer) Dropped() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+// Close exports any queued spans and releases the exporter
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	close(t.spans)
+	<-t.done
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Close()
+}
+
+// export sends queued spans to the exporter in batches
+func (t *Tracer) export() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]Span, 0, exportBatchSize)
+	flush := func() {
+		if len(batch) == 0 || t.exporter == nil {
+			batch = batch[:0]
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			fmt.Printf("Warning: exporting %d spans failed: %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span, ok := <-t.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) == exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+func TestTracer_Traceparent(t *testing.T) {
+	tracer, err := NewTracer(config.Tracing{Enabled: true, SampleRatio: 0.25})
+	if err != nil {
+		t.Fatalf("NewTracer returned error: %v", err)
+	}
+	defer tracer.Close()
+
+	format := regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-0[01]$`)
+	seen := make(map[string]bool)
+	sampled := 0
+	const n = 10000
+	for i := 0; i < n; i++ {
+		sc := tracer.Start()
+		header := sc.Traceparent()
+		if !format.MatchString(header) {
+			t.Fatalf("Traceparent() = %q, not a valid W3C traceparent", header)
+		}
+		if seen[sc.TraceIDString()] {
+			t.Fatalf("Trace ID %s generated twice", sc.TraceIDString())
+		}
+		seen[sc.TraceIDString()] = true
+		if sc.Sampled {
+			sampled++
+		}
+	}
+
+	if ratio := float64(sampled) / n; ratio < 0.22 || ratio > 0.28 {
+		t.Errorf("Expected about 25%% of requests sampled, got %.1f%%", 100*ratio)
+	}
+}
+
+func TestTracer_FileExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	tracer, err := NewTracer(config.Tracing{Enabled: true, SampleRatio: 1, File: path, ServiceName: "checkout-load"})
+	if err != nil {
+		t.Fatalf("NewTracer returned error: %v", err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		sc := tracer.Start()
+		span := Span{
+			Context:    sc,
+			Name:       "checkout",
+			Start:      start,
+			End:        start.Add(25 * time.Millisecond),
+			Attributes: map[string]interface{}{"http.response.status_code": 503},
+		}
+		if i == 2 {
+			span.Error = "HTTP 503 Service Unavailable"
+		}
+		tracer.Finish(span)
+	}
+	tracer.Finish(Span{Context: SpanContext{}}) // unsampled, not exported
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+
+	var spans []otlpSpan
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var traces otlpTraces
+		if err := json.Unmarshal(scanner.Bytes(), &traces); err != nil {
+			t.Fatalf("Line is not OTLP/JSON: %v", err)
+		}
+		rs := traces.ResourceSpans[0]
+		if name := *rs.Resource.Attributes[0].Value.StringValue; name != "checkout-load" {
+			t.Errorf("Expected service.name checkout-load, got %s", name)
+		}
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 exported spans, got %d", len(spans))
+	}
+	s := spans[2]
+	if s.Kind != spanKindClient || s.StartTimeUnixNano != "1700000000000000000" || s.EndTimeUnixNano != "1700000000025000000" {
+		t.Errorf("Unexpected span kind or timing: %+v", s)
+	}
+	if s.Status.Code != statusCodeError || s.Status.Message == "" {
+		t.Errorf("Expected an error status, got %+v", s.Status)
+	}
+	if v := s.Attributes[0].Value.IntValue; v == nil || *v != "503" {
+		t.Errorf("Expected an integer status code attribute, got %+v", s.Attributes)
+	}
+}
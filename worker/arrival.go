@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Arrival generates the spacing between consecutive requests as a multiple of
+// the mean interval at the current rate. Every distribution has a mean of one,
+// so the average rate matches the target rate whichever one is used.
+type Arrival interface {
+	Next() float64
+}
+
+// NewArrival creates the arrival process for the given distribution name.
+// Unknown names fall back to constant spacing, and a burst size below one to
+// bursts of one; the config is validated on load.
+func NewArrival(kind string, burstSize int) Arrival {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	switch kind {
+	case "poisson":
+		return &poissonArrival{rng: rng}
+	case "uniform":
+		return &uniformArrival{rng: rng}
+	case "burst":
+		if burstSize < 1 {
+			burstSize = 1
+		}
+		return &burstArrival{size: burstSize}
+	default:
+		return constantArrival{}
+	}
+}
+
+// constantArrival spaces requests evenly
+type constantArrival struct{}
+
+func (constantArrival) Next() float64 {
+	return 1
+}
+
+// poissonArrival draws exponential interarrival times, giving a Poisson process
+// of independent arrivals like a large population of clients
+type poissonArrival struct {
+	rng *rand.Rand
+}
+
+func (a *poissonArrival) Next() float64 {
+	return a.rng.ExpFloat64()
+}
+
+// uniformArrival draws interarrival times uniformly between zero and twice the mean
+type uniformArrival struct {
+	rng *rand.Rand
+}
+
+func (a *uniformArrival) Next() float64 {
+	return a.rng.Float64() * 2
+}
+
+// burstArrival sends requests in groups of size at once, with the groups spaced
+// so the average rate is unchanged
+type burstArrival struct {
+	size  int
+	count int
+}
+
+func (a *burstArrival) Next() float64 {
+	a.count++
+	if a.count%a.size != 0 {
+		return 0
+	}
+	return float64(a.size)
+}
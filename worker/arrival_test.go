@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"math"
+	"testing"
+)
+
+func TestArrival_Distributions(t *testing.T) {
+	tests := []struct {
+		kind string
+		cv   float64 // expected coefficient of variation of the spacing
+	}{
+		{"constant", 0},
+		{"uniform", 1 / math.Sqrt(3)},
+		{"poisson", 1},
+	}
+
+	const samples = 200000
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			arrival := NewArrival(tt.kind, 0)
+
+			var sum, sumSq float64
+			for i := 0; i < samples; i++ {
+				gap := arrival.Next()
+				if gap < 0 {
+					t.Fatalf("Negative spacing %v", gap)
+				}
+				sum += gap
+				sumSq += gap * gap
+			}
+
+			mean := sum / samples
+			cv := math.Sqrt(sumSq/samples-mean*mean) / mean
+			if math.Abs(mean-1) > 0.01 {
+				t.Errorf("Expected mean spacing of 1, got %.4f", mean)
+			}
+			if math.Abs(cv-tt.cv) > 0.02 {
+				t.Errorf("Expected coefficient of variation %.3f, got %.3f", tt.cv, cv)
+			}
+		})
+	}
+}
+
+func TestArrival_Burst(t *testing.T) {
+	arrival := NewArrival("burst", 3)
+
+	want := []float64{0, 0, 3, 0, 0, 3}
+	for i, w := range want {
+		if got := arrival.Next(); got != w {
+			t.Errorf("Next() #%d = %v, want %v", i+1, got, w)
+		}
+	}
+
+	// A size that never passed validation spaces requests evenly
+	for _, size := range []int{0, -2} {
+		arrival := NewArrival("burst", size)
+		for i := 0; i < 3; i++ {
+			if got := arrival.Next(); got != 1 {
+				t.Errorf("Next() with burst size %d = %v, want 1", size, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+
+	"protobuf/config"
+
+	"github.com/valyala/fasthttp"
+)
+
+// checkResponse checks a response against the endpoint assertions. It returns
+// the error class and a message if the response fails, or an empty class if it
+// passes. Without a status assertion any 2xx status is accepted, and any other
+// status is an HTTP status error rather than an assertion failure.
+func checkResponse(assert *config.Assertions, resp *fasthttp.Response) (string, string) {
+	status := resp.StatusCode()
+	if len(assert.Status) > 0 {
+		if !containsInt(assert.Status, status) {
+			return ErrorAssertion, fmt.Sprintf("status %d not in %v", status, assert.Status)
+		}
+	} else if status < 200 || status >= 300 {
+		return ErrorHTTPStatus, statusMessage(status)
+	}
+
+	if assert.BodyContains != "" && !bytes.Contains(resp.Body(), []byte(assert.BodyContains)) {
+		return ErrorAssertion, fmt.Sprintf("body does not contain %q", assert.BodyContains)
+	}
+	return "", ""
+}
+
+// containsInt reports whether n is in list
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"protobuf/config"
+)
+
+// SetRate sets the target rate of an open model test. The rate then stays
+// under manual control: the load pattern no longer changes it, but still
+// names its stages.
+func (p *Pool) SetRate(rps int) error {
+	if p.config.Model == "closed" {
+		return fmt.Errorf("the closed model has no target rate")
+	}
+	if rps < 1 {
+		return fmt.Errorf("rate must be at least 1 RPS, pause the test to stop sending")
+	}
+	if p.config.MaxRPS > 0 && rps > p.config.MaxRPS {
+		return fmt.Errorf("rate %d RPS is above max_rps %d", rps, p.config.MaxRPS)
+	}
+
+	p.control.Lock()
+	p.manualRate = true
+	p.targetRPS = rps
+	if p.resume == nil {
+		p.rateLimiter.UpdateRate(rps)
+	}
+	p.control.Unlock()
+
+	p.Annotate(fmt.Sprintf("rate set to %d RPS", rps))
+	return nil
+}
+
+// Pause stops sending new requests until Resume is called. Requests in flight
+// finish as usual.
+func (p *Pool) Pause() error {
+	p.control.Lock()
+	if p.resume != nil {
+		p.control.Unlock()
+		return fmt.Errorf("already paused")
+	}
+	p.resume = make(chan struct{})
+	if p.config.Model != "closed" {
+		p.rateLimiter.UpdateRate(0)
+	}
+	p.control.Unlock()
+
+	p.Annotate("paused")
+	return nil
+}
+
+// Resume continues a paused test at the rate it would otherwise be running at
+func (p *Pool) Resume() error {
+	p.control.Lock()
+	if p.resume == nil {
+		p.control.Unlock()
+		return fmt.Errorf("not paused")
+	}
+	close(p.resume)
+	p.resume = nil
+	if p.config.Model != "closed" {
+		p.rateLimiter.UpdateRate(p.targetRPS)
+	}
+	p.control.Unlock()
+
+	p.Annotate("resumed")
+	return nil
+}
+
+// SetStage moves the load pattern to the start of one of its stages: a
+// ramp-up jumps to the rate of that step and carries on from it, and a curve
+// starts over. A rate set through SetRate still holds. The warmup cannot be
+// moved into or out of, as it decides which requests count as warmup.
+func (p *Pool) SetStage(stage string) error {
+	if !p.config.HasStage(stage) {
+		want := fmt.Sprintf("%q", p.config.InitialStage())
+		if p.config.Model != "closed" && p.config.LoadPattern.Type == "ramp-up" {
+			want = `"ramp-up step N"`
+		}
+		return fmt.Errorf("unknown stage %q, the load pattern's stages are %s", stage, want)
+	}
+	if stage == config.WarmupStage {
+		return fmt.Errorf("the warmup cannot be restarted")
+	}
+	if time.Now().Before(p.warmupEnd) {
+		return fmt.Errorf("the warmup is still running")
+	}
+
+	// The closed model has a single stage after the warmup, so there is
+	// nowhere to move to. Otherwise the load pattern restarts its schedule
+	// from the stage, and the stage's rate is set here, a full tick before
+	// the schedule next changes it.
+	if p.config.Model != "closed" {
+		select {
+		case p.stages <- stage:
+		case <-p.stopChan:
+			return fmt.Errorf("the test has stopped")
+		}
+		p.updateRate(stageRPS(p.config, stage))
+		p.setStage(stage)
+	}
+
+	p.Annotate(fmt.Sprintf("stage set to %s", stage))
+	return nil
+}
+
+// Annotate records a change to the running test in the time series, on the
+// interval open at the time
+func (p *Pool) Annotate(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.stopped.IsZero() {
+		return
+	}
+	p.annotations = append(p.annotations, config.Annotation{At: time.Since(p.started), Text: text})
+}
+
+// paused returns a channel that is closed on resume, or nil if the test is
+// not paused
+func (p *Pool) paused() <-chan struct{} {
+	p.control.Lock()
+	defer p.control.Unlock()
+	return p.resume
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"math"
+	"time"
+
+	"protobuf/config"
+)
+
+// curveUpdateInterval is how often the curve pattern recomputes the target
+// rate when the load pattern does not set an interval
+const curveUpdateInterval = 100 * time.Millisecond
+
+// curveRate returns the target RPS of the curve at the given offset into the test.
+// Point curves are linearly interpolated and hold their first and last values
+// outside the defined range.
+func curveRate(c *config.Curve, elapsed time.Duration) float64 {
+	if len(c.Points) > 0 {
+		return interpolatePoints(c.Points, elapsed.Seconds())
+	}
+
+	switch c.Function {
+	case "sine":
+		t := (elapsed + c.Phase).Seconds()
+		return c.Base + c.Amplitude*math.Sin(2*math.Pi*t/c.Period.Seconds())
+	}
+	return 0
+}
+
+// interpolatePoints linearly interpolates the rate at t seconds
+func interpolatePoints(points []config.CurvePoint, t float64) float64 {
+	if t <= points[0].Seconds {
+		return points[0].RPS
+	}
+
+	for i := 1; i < len(points); i++ {
+		prev, next := points[i-1], points[i]
+		if t > next.Seconds {
+			continue
+		}
+		span := next.Seconds - prev.Seconds
+		if span == 0 {
+			return next.RPS
+		}
+		return prev.RPS + (next.RPS-prev.RPS)*(t-prev.Seconds)/span
+	}
+
+	return points[len(points)-1].RPS
+}
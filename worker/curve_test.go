@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+func TestCurveRate_Points(t *testing.T) {
+	curve := &config.Curve{
+		Points: []config.CurvePoint{
+			{Seconds: 0, RPS: 10},
+			{Seconds: 10, RPS: 110},
+			{Seconds: 20, RPS: 50},
+		},
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 10},
+		{5 * time.Second, 60},
+		{10 * time.Second, 110},
+		{15 * time.Second, 80},
+		{time.Minute, 50},
+	}
+
+	for _, tt := range tests {
+		if got := curveRate(curve, tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("curveRate(%v) = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestCurveRate_Sine(t *testing.T) {
+	curve := &config.Curve{
+		Function:  "sine",
+		Base:      100,
+		Amplitude: 50,
+		Period:    4 * time.Second,
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 100},
+		{time.Second, 150},
+		{2 * time.Second, 100},
+		{3 * time.Second, 50},
+	}
+
+	for _, tt := range tests {
+		if got := curveRate(curve, tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("curveRate(%v) = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Classes failed requests are counted under
+const (
+	ErrorHTTPStatus        = "http_status"        // response outside the accepted status codes
+	ErrorTimeout           = "timeout"            // no response within the request timeout
+	ErrorConnectionRefused = "connection_refused" // nothing listening on the target port
+	ErrorConnectionReset   = "connection_reset"   // connection dropped by the server or network
+	ErrorDNS               = "dns"                // host name could not be resolved
+	ErrorTLS               = "tls"                // handshake or certificate verification failed
+	ErrorTemplate          = "template"           // request template could not be rendered
+	ErrorBodyBuild         = "body_build"         // request body could not be built
+	ErrorAssertion         = "assertion"          // response failed an endpoint assertion
+	ErrorOther             = "other"              // any other transport failure
+)
+
+// maxErrorSamples is how many distinct error messages are kept per class
+const maxErrorSamples = 5
+
+// classifyError returns the class of a transport error
+func classifyError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, fasthttp.ErrTimeout), errors.Is(err, fasthttp.ErrDialTimeout),
+		errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, fasthttp.ErrConnectionClosed),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorConnectionReset
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		strings.HasPrefix(err.Error(), "tls:"):
+		return ErrorTLS
+	default:
+		return ErrorOther
+	}
+}
+
+// statusMessage describes an unexpected HTTP status
+func statusMessage(status int) string {
+	return fmt.Sprintf("HTTP %d %s", status, fasthttp.StatusMessage(status))
+}
+
+// errorRecord counts the failures of one class and keeps a few sample messages
+type errorRecord struct {
+	count   int64
+	samples []string
+}
+
+// add counts a failure, keeping its message if it is new and there is room
+func (e *errorRecord) add(count int64, messages ...string) {
+	e.count += count
+	for _, message := range messages {
+		if len(e.samples) >= maxErrorSamples {
+			return
+		}
+		if message == "" || containsString(e.samples, message) {
+			continue
+		}
+		e.samples = append(e.samples, message)
+	}
+}
+
+// containsString reports whether s is in list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
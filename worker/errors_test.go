@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"protobuf/config"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestClassifyError(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	reset := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer reset.Close()
+
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+
+	// A port that was just released has nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+	refused := "http://" + listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"refused", refused, ErrorConnectionRefused},
+		{"timeout", slow.URL, ErrorTimeout},
+		{"reset", reset.URL, ErrorConnectionReset},
+		{"tls", untrusted.URL, ErrorTLS},
+		{"dns", "http://no-such-host.invalid/", ErrorDNS},
+	}
+
+	client := &fasthttp.Client{MaxIdemponentCallAttempts: 1}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := fasthttp.AcquireRequest()
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseRequest(req)
+			defer fasthttp.ReleaseResponse(resp)
+			req.SetRequestURI(tt.url)
+
+			err := client.DoTimeout(req, resp, 50*time.Millisecond)
+			if err == nil {
+				t.Fatal("Expected the request to fail")
+			}
+			if got := classifyError(err); got != tt.want {
+				t.Errorf("classifyError(%v) = %s, want %s", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPool_ErrorClasses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{
+			{Name: "missing", URL: server.URL + "/missing", Method: "GET"},
+			{Name: "accepted", URL: server.URL + "/missing", Method: "GET",
+				Assert: config.Assertions{Status: []int{404}}},
+			{Name: "body", URL: server.URL, Method: "GET",
+				Assert: config.Assertions{BodyContains: `"ok":false`}},
+		},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 60},
+	}
+
+	metrics := runPool(t, 4, cfg, time.Second)
+
+	missing, accepted, body := metrics.Endpoints[0], metrics.Endpoints[1], metrics.Endpoints[2]
+	if missing.TotalRequests == 0 || accepted.TotalRequests == 0 || body.TotalRequests == 0 {
+		t.Fatalf("Expected requests to every endpoint, got %d, %d and %d",
+			missing.TotalRequests, accepted.TotalRequests, body.TotalRequests)
+	}
+
+	if got := missing.Errors[ErrorHTTPStatus]; got.Count != missing.TotalRequests || len(got.Samples) != 1 {
+		t.Errorf("Expected every missing request to fail with one %s sample, got %+v", ErrorHTTPStatus, got)
+	}
+	if accepted.FailedRequests != 0 {
+		t.Errorf("Expected an asserted 404 to succeed, got errors %v", accepted.Errors)
+	}
+	if got := body.Errors[ErrorAssertion].Count; got != body.TotalRequests {
+		t.Errorf("Expected every body request to fail its assertion, got %d of %d", got, body.TotalRequests)
+	}
+	if metrics.StatusCodes[404] != missing.TotalRequests+accepted.TotalRequests {
+		t.Errorf("Expected 404s from both 404 endpoints, got %v", metrics.StatusCodes)
+	}
+}
@@ -0,0 +1,227 @@
+package worker
+
+import (
+	"sort"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+// Merge combines the results of tests run side by side against the same
+// endpoints, such as those of the agents of a distributed test, into one.
+// Counters are summed and latency histograms merged, so run-wide and
+// per-endpoint percentiles are exact. Time series intervals are lined up by
+// index: counts and rates are summed, but intervals keep no histograms, so
+// their percentiles are the worst of any result. The current rate comes from
+// the last interval every result completed in full.
+func Merge(results []*config.Metrics) *config.Metrics {
+	m := &config.Metrics{StatusCodes: make(map[int]int64)}
+	serviceTime := metrics.NewHistogram(latencyPrecision)
+	responseTime := metrics.NewHistogram(latencyPrecision)
+	errs := make(map[string]*errorRecord)
+	var phases *phaseHistograms
+	var slowest []config.TracedRequest
+	var warmups []*config.Metrics
+
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		m.TotalRequests += r.TotalRequests
+		m.SuccessfulRequests += r.SuccessfulRequests
+		m.FailedRequests += r.FailedRequests
+		m.ScheduledRequests += r.ScheduledRequests
+		m.DroppedRequests += r.DroppedRequests
+		m.CancelledRequests += r.CancelledRequests
+		m.LateRequests += r.LateRequests
+		m.ActiveWorkers += r.ActiveWorkers
+		m.PeakWorkers += r.PeakWorkers
+		m.InFlight += r.InFlight
+		m.TargetRPS += r.TargetRPS
+		m.Paused = m.Paused || r.Paused
+		if m.Stage == "" {
+			m.Stage = r.Stage
+		}
+		if r.Elapsed > m.Elapsed {
+			m.Elapsed = r.Elapsed
+		}
+
+		mergeHistogram(serviceTime, r.LatencyStats)
+		mergeHistogram(responseTime, r.ResponseTimeStats)
+		for class, e := range r.Errors {
+			mergeErrorRecord(errs, class, &errorRecord{count: e.Count, samples: e.Samples})
+		}
+		for status, count := range r.StatusCodes {
+			m.StatusCodes[status] += count
+		}
+		for _, traced := range r.SlowestTraced {
+			slowest = keepSlowest(slowest, traced)
+		}
+		if r.Phases != nil {
+			if phases == nil {
+				phases = newPhaseHistograms()
+			}
+			phases.mergeStats(r.Phases)
+		}
+		if r.Warmup != nil {
+			warmups = append(warmups, r.Warmup)
+		}
+	}
+
+	if m.Elapsed > 0 {
+		m.AchievedRPS = float64(m.TotalRequests) / m.Elapsed.Seconds()
+	}
+	m.LatencyStats = latencyStats(serviceTime)
+	m.ResponseTimeStats = latencyStats(responseTime)
+	m.Errors = errorStats(errs)
+	sort.Slice(slowest, func(i, j int) bool {
+		return slowest[i].ResponseTime > slowest[j].ResponseTime
+	})
+	m.SlowestTraced = slowest
+	if phases != nil {
+		m.Phases = phases.stats()
+	}
+	m.Endpoints = mergeEndpoints(results, m.Elapsed)
+	m.TimeSeries = mergeTimeSeries(results)
+	m.CurrentRPS = currentRPS(m.TimeSeries)
+	if len(warmups) > 0 {
+		m.Warmup = Merge(warmups)
+	}
+	return m
+}
+
+// mergeEndpoints combines the per-endpoint results, which every result lists
+// in the order of the config
+func mergeEndpoints(results []*config.Metrics, elapsed time.Duration) []config.EndpointMetrics {
+	var merged []config.EndpointMetrics
+	var serviceTimes, responseTimes []*metrics.Histogram
+	var errs []map[string]*errorRecord
+	var phases []*phaseHistograms
+
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		for i, e := range r.Endpoints {
+			if i == len(merged) {
+				merged = append(merged, config.EndpointMetrics{Name: e.Name, StatusCodes: make(map[int]int64)})
+				serviceTimes = append(serviceTimes, metrics.NewHistogram(latencyPrecision))
+				responseTimes = append(responseTimes, metrics.NewHistogram(latencyPrecision))
+				errs = append(errs, make(map[string]*errorRecord))
+				phases = append(phases, nil)
+			}
+			em := &merged[i]
+			em.TotalRequests += e.TotalRequests
+			em.SuccessfulRequests += e.SuccessfulRequests
+			em.FailedRequests += e.FailedRequests
+			for status, count := range e.StatusCodes {
+				em.StatusCodes[status] += count
+			}
+			for class, stats := range e.Errors {
+				mergeErrorRecord(errs[i], class, &errorRecord{count: stats.Count, samples: stats.Samples})
+			}
+			mergeHistogram(serviceTimes[i], e.LatencyStats)
+			mergeHistogram(responseTimes[i], e.ResponseTimeStats)
+			if e.Phases != nil {
+				if phases[i] == nil {
+					phases[i] = newPhaseHistograms()
+				}
+				phases[i].mergeStats(e.Phases)
+			}
+		}
+	}
+
+	for i := range merged {
+		em := &merged[i]
+		if elapsed > 0 {
+			em.RPS = float64(em.TotalRequests) / elapsed.Seconds()
+		}
+		em.Errors = errorStats(errs[i])
+		em.LatencyStats = latencyStats(serviceTimes[i])
+		em.ResponseTimeStats = latencyStats(responseTimes[i])
+		if phases[i] != nil {
+			em.Phases = phases[i].stats()
+		}
+	}
+	return merged
+}
+
+// mergeTimeSeries lines the intervals of every result up by index. A merged
+// interval is partial unless every result completed it in full, so its rates
+// are not summed over only some of the results.
+func mergeTimeSeries(results []*config.Metrics) []config.IntervalMetrics {
+	var merged []config.IntervalMetrics
+	var full []int
+	n := 0
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		n++
+		for i, interval := range r.TimeSeries {
+			if i == len(merged) {
+				merged = append(merged, config.IntervalMetrics{
+					Start:    interval.Start,
+					Duration: interval.Duration,
+					Stage:    interval.Stage,
+				})
+				full = append(full, 0)
+			}
+			if !interval.Partial {
+				full[i]++
+			}
+			im := &merged[i]
+			if interval.Duration > im.Duration {
+				im.Duration = interval.Duration
+			}
+			im.TargetRPS += interval.TargetRPS
+			im.AchievedRPS += interval.AchievedRPS
+			im.Requests += interval.Requests
+			im.Failed += interval.Failed
+			im.Dropped += interval.Dropped
+			im.P50 = maxDuration(im.P50, interval.P50)
+			im.P95 = maxDuration(im.P95, interval.P95)
+			im.P99 = maxDuration(im.P99, interval.P99)
+			im.Max = maxDuration(im.Max, interval.Max)
+			im.Annotations = append(im.Annotations, interval.Annotations...)
+		}
+	}
+
+	for i := range merged {
+		if merged[i].Requests > 0 {
+			merged[i].ErrorRate = float64(merged[i].Failed) / float64(merged[i].Requests)
+		}
+		merged[i].Partial = full[i] < n
+	}
+	return merged
+}
+
+// mergeHistogram adds the histogram behind s to h, if it has one
+func mergeHistogram(h *metrics.Histogram, s config.LatencyStats) {
+	if s.Histogram != nil {
+		h.Merge(s.Histogram)
+	}
+}
+
+// mergeStats adds the histograms behind each phase of p to h
+func (h *phaseHistograms) mergeStats(p *config.PhaseStats) {
+	for i, s := range []config.LatencyStats{
+		phaseDNS:      p.DNS,
+		phaseConnect:  p.Connect,
+		phaseTLS:      p.TLS,
+		phaseWrite:    p.Write,
+		phaseTTFB:     p.TTFB,
+		phaseTransfer: p.Transfer,
+	} {
+		mergeHistogram(h[i], s)
+	}
+}
+
+// maxDuration returns the longer of a and b
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
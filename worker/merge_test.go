@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+func TestMerge_CurrentRPS(t *testing.T) {
+	interval := func(start time.Duration, rps float64, partial bool) config.IntervalMetrics {
+		duration := time.Second
+		if partial {
+			duration = 200 * time.Millisecond
+		}
+		return config.IntervalMetrics{Start: start, Duration: duration, AchievedRPS: rps, Partial: partial}
+	}
+	// The second agent stopped a tick earlier, so its last full interval is
+	// not aligned with the first agent's
+	results := []*config.Metrics{
+		{CurrentRPS: 60, TimeSeries: []config.IntervalMetrics{
+			interval(0, 40, false), interval(time.Second, 60, false), interval(2*time.Second, 10, true),
+		}},
+		{CurrentRPS: 40, TimeSeries: []config.IntervalMetrics{
+			interval(0, 40, false), interval(time.Second, 5, true),
+		}},
+	}
+
+	m := Merge(results)
+	if m.CurrentRPS != 80 {
+		t.Errorf("Expected 80 RPS from the last interval both agents completed, got %v", m.CurrentRPS)
+	}
+	for i, want := range []bool{false, true, true} {
+		if m.TimeSeries[i].Partial != want {
+			t.Errorf("Expected interval %d partial %v, got %v", i, want, m.TimeSeries[i].Partial)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Phases of a request, in the order they happen
+const (
+	phaseDNS = iota
+	phaseConnect
+	phaseTLS
+	phaseWrite
+	phaseTTFB
+	phaseTransfer
+	numPhases
+)
+
+// phaseTimings is how long each phase of a single request took. Phases that
+// did not happen, such as DNS and connect on a reused connection, are zero.
+type phaseTimings [numPhases]time.Duration
+
+// phaseHistograms holds the durations of each phase
+type phaseHistograms [numPhases]*metrics.Histogram
+
+// newPhaseHistograms creates an empty histogram per phase
+func newPhaseHistograms() *phaseHistograms {
+	var h phaseHistograms
+	for i := range h {
+		h[i] = metrics.NewHistogram(latencyPrecision)
+	}
+	return &h
+}
+
+// record adds the phases that happened
+func (h *phaseHistograms) record(t *phaseTimings) {
+	for i, d := range t {
+		if d > 0 {
+			h[i].Record(d)
+		}
+	}
+}
+
+// merge adds every phase of other to h
+func (h *phaseHistograms) merge(other *phaseHistograms) {
+	for i := range h {
+		h[i].Merge(other[i])
+	}
+}
+
+// stats derives the latency statistics of each phase
+func (h *phaseHistograms) stats() *config.PhaseStats {
+	return &config.PhaseStats{
+		DNS:      latencyStats(h[phaseDNS]),
+		Connect:  latencyStats(h[phaseConnect]),
+		TLS:      latencyStats(h[phaseTLS]),
+		Write:    latencyStats(h[phaseWrite]),
+		TTFB:     latencyStats(h[phaseTTFB]),
+		Transfer: latencyStats(h[phaseTransfer]),
+	}
+}
+
+// phaseClient sends requests with net/http, whose httptrace hooks time each
+// phase of a request. fasthttp has no such hooks, so this client is only used
+// when phase timings are enabled.
+type phaseClient struct {
+	client *http.Client
+}
+
+// newPhaseClient creates a client with the given request timeout, none if zero
+func newPhaseClient(timeout time.Duration) *phaseClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Keep as many idle connections as fasthttp does, so connections are
+	// reused at the same rate and new-connection phases stay comparable
+	transport.MaxIdleConnsPerHost = fasthttp.DefaultMaxConnsPerHost
+	// Like fasthttp, leave the body as sent and redirects unfollowed, so
+	// enabling phase timings does not change the responses measured
+	transport.DisableCompression = true
+	return &phaseClient{client: &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// do sends req, copies the response into resp and returns the phase timings
+func (c *phaseClient) do(req *fasthttp.Request, resp *fasthttp.Response) (*phaseTimings, error) {
+	httpReq, err := http.NewRequest(string(req.Header.Method()), req.URI().String(), bytes.NewReader(req.Body()))
+	if err != nil {
+		return nil, err
+	}
+	// net/http sets the framing headers itself, and takes the host apart
+	req.Header.VisitAll(func(key, value []byte) {
+		switch http.CanonicalHeaderKey(string(key)) {
+		case "Host", "Content-Length", "Connection":
+			return
+		}
+		httpReq.Header.Add(string(key), string(value))
+	})
+	if host := req.Header.Host(); len(host) > 0 {
+		httpReq.Host = string(host)
+	}
+
+	// Hooks may run on the transport's goroutines, and connect may start
+	// more than once when dialing several addresses
+	var (
+		mu                               sync.Mutex
+		t                                phaseTimings
+		dnsStart, connectStart, tlsStart time.Time
+		gotConn, wrote, firstByte        time.Time
+	)
+	mark := func(at *time.Time) {
+		mu.Lock()
+		if at.IsZero() {
+			*at = time.Now()
+		}
+		mu.Unlock()
+	}
+	since := func(phase int, start *time.Time) {
+		mu.Lock()
+		if !start.IsZero() {
+			t[phase] = time.Since(*start)
+		}
+		mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { mark(&dnsStart) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { since(phaseDNS, &dnsStart) },
+		ConnectStart: func(string, string) { mark(&connectStart) },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				since(phaseConnect, &connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { mark(&tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { since(phaseTLS, &tlsStart) },
+		GotConn:           func(httptrace.GotConnInfo) { mark(&gotConn) },
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mark(&wrote)
+			since(phaseWrite, &gotConn)
+		},
+		GotFirstResponseByte: func() {
+			mark(&firstByte)
+			since(phaseTTFB, &wrote)
+		},
+	}
+	httpReq = httpReq.WithContext(httptrace.WithClientTrace(httpReq.Context(), trace))
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	since(phaseTransfer, &firstByte)
+
+	resp.SetStatusCode(httpResp.StatusCode)
+	for key, values := range httpResp.Header {
+		for _, value := range values {
+			resp.Header.Add(key, value)
+		}
+	}
+	resp.SetBody(body)
+
+	mu.Lock()
+	timings := t
+	mu.Unlock()
+	return &timings, nil
+}
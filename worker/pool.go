@@ -4,116 +4,563 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"protobuf/config"
+	"protobuf/requestlog"
 	"protobuf/template"
+	"protobuf/tracing"
 
 	"github.com/valyala/fasthttp"
 )
 
-// Pool represents a worker pool for handling concurrent requests
+// defaultLateThreshold is how far behind schedule a request may start before
+// it is counted as late, when the config does not set late_threshold
+const defaultLateThreshold = 10 * time.Millisecond
+
+// defaultDrainTimeout is how long requests in flight at the end of the test
+// may take to finish, when the config does not set drain_timeout
+const defaultDrainTimeout = 10 * time.Second
+
+// defaultIdleTimeout is how long a worker above the minimum may sit idle before
+// it exits, when the config does not set concurrency.idle_timeout
+const defaultIdleTimeout = 5 * time.Second
+
+// Pool represents a worker pool for handling concurrent requests. With
+// concurrency.max set, the pool grows beyond its initial workers whenever
+// they are all busy and shrinks back as extra workers go idle.
 type Pool struct {
-	workers     int
-	jobs        chan struct{}
-	metrics     *config.Metrics
-	client      *fasthttp.Client
-	config      *config.Config
-	wg          sync.WaitGroup
-	mu          sync.Mutex
-	processor   *template.Processor
-	latencies   []time.Duration
-	rateLimiter *RateLimiter
-	stopChan    chan struct{}
+	workers         int // minimum number of workers
+	maxWorkers      int
+	idleTimeout     time.Duration
+	activeWorkers   atomic.Int64
+	peakWorkers     atomic.Int64
+	scheduled       counter
+	dropped         counter
+	cancelled       counter
+	busy            counter      // requests taken by a worker and not yet recorded
+	inFlight        atomic.Int64 // requests sent and awaiting a response
+	jobs            chan job
+	recorders       []*recorder // one per worker that has run, guarded by mu
+	client          *fasthttp.Client
+	phaseClient     *phaseClient // used instead of client when phase timings are enabled
+	config          *config.Config
+	wg              sync.WaitGroup // the dispatcher, load pattern controller and interval sampler
+	workersWG       sync.WaitGroup // workers and virtual users
+	mu              sync.Mutex
+	closeMu         sync.RWMutex // held for reading while a result is recorded
+	closed          bool         // set once the drain is over, results after it are discarded; guarded by closeMu
+	drainTimeout    time.Duration
+	processor       *template.Processor
+	lateThreshold   time.Duration
+	rateLimiter     *RateLimiter
+	metricsInterval time.Duration
+	series          []config.IntervalMetrics // guarded by mu
+	interval        intervalState            // start of the open interval, guarded by mu
+	stage           string                   // current load stage, guarded by mu
+	prevStage       string                   // stage before the current one, guarded by mu
+	stageSince      time.Time                // when the current stage began, guarded by mu
+	warmup          time.Duration
+	warmupEnd       time.Time           // requests scheduled before this are warmup, set by Start
+	annotations     []config.Annotation // made since the last interval closed, guarded by mu
+	control         sync.Mutex          // guards manualRate, resume and targetRPS
+	manualRate      bool                // the control API has taken the rate over from the load pattern
+	stages          chan string         // stages set through the control API, for the load pattern to move to
+	resume          chan struct{}       // closed on resume, nil unless paused
+	targetRPS       int                 // rate the test runs at when not paused
+	tracer          *tracing.Tracer
+	requestLog      *requestlog.Writer
+	stopChan        chan struct{}
+	cancel          context.CancelFunc
+	started         time.Time
+	stopped         time.Time
+}
+
+// job is a single request handed from the dispatcher to a worker
+type job struct {
+	intended time.Time // when the schedule called for the request to be sent
+}
+
+// counter counts requests, keeping apart those scheduled during warmup
+type counter struct {
+	all    atomic.Int64
+	warmup atomic.Int64
+}
+
+// add adds n requests
+func (c *counter) add(warmup bool, n int64) {
+	c.all.Add(n)
+	if warmup {
+		c.warmup.Add(n)
+	}
+}
+
+// measured returns the requests scheduled after the warmup
+func (c *counter) measured() int64 {
+	return c.all.Load() - c.warmup.Load()
 }
 
 // NewPool creates a new worker pool
 func NewPool(workers int, cfg *config.Config) *Pool {
+	rps := initialRPS(cfg)
+	if cfg.Warmup.Duration > 0 && cfg.Warmup.RPS > 0 {
+		rps = cfg.Warmup.RPS
+	}
+	rateLimiter := NewRateLimiter(rps)
+	rateLimiter.SetArrival(NewArrival(cfg.LoadPattern.Arrival, cfg.LoadPattern.BurstSize))
+
+	lateThreshold := cfg.LateThreshold
+	if lateThreshold <= 0 {
+		lateThreshold = defaultLateThreshold
+	}
+
+	if cfg.Concurrency.Min > 0 {
+		workers = cfg.Concurrency.Min
+	}
+	maxWorkers := cfg.Concurrency.Max
+	if maxWorkers < workers {
+		maxWorkers = workers
+	}
+	idleTimeout := cfg.Concurrency.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	metricsInterval := cfg.MetricsInterval
+	if metricsInterval <= 0 {
+		metricsInterval = defaultMetricsInterval
+	}
+	drainTimeout := cfg.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+
+	processor := template.NewProcessor()
+	processor.SetPartition(cfg.Partition.Index, cfg.Partition.Count)
+
+	var phases *phaseClient
+	if cfg.PhaseTimings {
+		phases = newPhaseClient(cfg.Timeout)
+	}
+
 	return &Pool{
-		workers:     workers,
-		jobs:        make(chan struct{}, workers),
-		metrics:     &config.Metrics{},
-		client:      &fasthttp.Client{},
-		config:      cfg,
-		processor:   template.NewProcessor(),
-		latencies:   make([]time.Duration, 0, 1000),
-		rateLimiter: NewRateLimiter(cfg.LoadPattern.StartRPS),
-		stopChan:    make(chan struct{}),
+		workers:         workers,
+		maxWorkers:      maxWorkers,
+		idleTimeout:     idleTimeout,
+		jobs:            make(chan job, workers),
+		client:          &fasthttp.Client{},
+		phaseClient:     phases,
+		config:          cfg,
+		processor:       processor,
+		lateThreshold:   lateThreshold,
+		rateLimiter:     rateLimiter,
+		metricsInterval: metricsInterval,
+		drainTimeout:    drainTimeout,
+		targetRPS:       rps,
+		warmup:          cfg.Warmup.Duration,
+		stopChan:        make(chan struct{}),
+		stages:          make(chan string),
+	}
+}
+
+// SetTracer propagates trace context on every request and exports the spans
+// of sampled ones through t. It must be called before Start.
+func (p *Pool) SetTracer(t *tracing.Tracer) {
+	p.tracer = t
+}
+
+// SetRequestLog writes a record of every request to w. It must be called
+// before Start.
+func (p *Pool) SetRequestLog(w *requestlog.Writer) {
+	p.requestLog = w
+}
+
+// initialRPS returns the rate the test starts at
+func initialRPS(cfg *config.Config) int {
+	if cfg.LoadPattern.Type == "curve" {
+		return clampRPS(curveRate(&cfg.LoadPattern.Curve, 0), cfg.MaxRPS)
+	}
+	return cfg.LoadPattern.StartRPS
+}
+
+// clampRPS rounds a fractional rate to at least 1 RPS and at most maxRPS, if set
+func clampRPS(rps float64, maxRPS int) int {
+	rate := int(math.Round(rps))
+	if maxRPS > 0 && rate > maxRPS {
+		rate = maxRPS
+	}
+	if rate < 1 {
+		rate = 1
 	}
+	return rate
 }
 
 // Start begins the stress test
 func (p *Pool) Start(ctx context.Context) {
-	p.wg.Add(p.workers + 2) // +1 for the load pattern controller, +1 for job generator
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.started = time.Now()
+	p.warmupEnd = p.started.Add(p.warmup)
+	p.interval = intervalState{start: p.started}
+	if p.warmup > 0 {
+		p.setStage(config.WarmupStage)
+	} else {
+		p.setStage(p.config.InitialStage())
+	}
+
+	p.wg.Add(1)
+	go p.sampleIntervals(ctx)
+
+	if p.config.Model == "closed" {
+		if p.warmup > 0 {
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				p.warmUp(ctx)
+			}()
+		}
+		p.startVirtualUsers(ctx)
+		return
+	}
+
+	p.wg.Add(2) // +1 for the load pattern controller, +1 for the dispatcher
 
 	// Start load pattern controller
 	go p.controlLoadPattern(ctx)
 
 	// Start workers
 	for i := 0; i < p.workers; i++ {
-		go p.worker(ctx)
+		p.startWorker(ctx)
 	}
 
-	// Start job generator
-	go func() {
-		defer p.wg.Done()
-		ticker := time.NewTicker(time.Second / time.Duration(p.config.LoadPattern.StartRPS))
-		defer ticker.Stop()
+	// Start dispatcher
+	go p.dispatch(ctx)
+}
+
+// dispatch hands jobs to the workers at the rate set on the rate limiter. When
+// every worker is busy it starts another one, up to the maximum; beyond that
+// the job is dropped and counted, since waiting for a free worker would
+// silently lower the offered load.
+func (p *Pool) dispatch(ctx context.Context) {
+	defer p.wg.Done()
+
+	start := time.Now()
+	saturated := false
+	for {
+		intended, err := p.rateLimiter.Wait(ctx)
+		if err != nil {
+			return
+		}
+		j := job{intended: intended}
+		warmup := p.isWarmup(intended)
+		p.scheduled.add(warmup, 1)
 
-		for {
+		select {
+		case <-p.stopChan:
+			p.cancelled.add(warmup, 1)
+			return
+		case p.jobs <- j:
+			// Job sent successfully
+			saturated = false
+			continue
+		default:
+		}
+
+		if p.activeWorkers.Load() < int64(p.maxWorkers) {
+			p.startWorker(ctx)
+			select {
+			case <-p.stopChan:
+				p.cancelled.add(warmup, 1)
+				return
+			case <-ctx.Done():
+				p.cancelled.add(warmup, 1)
+				return
+			case p.jobs <- j:
+				continue
+			}
+		}
+
+		// Every worker is busy, skip this job. The warning goes to stderr, so
+		// it does not land in the dashboard or the log lines on stdout.
+		p.dropped.add(warmup, 1)
+		if !saturated {
+			saturated = true
+			fmt.Fprintf(os.Stderr, "Warning: all %d workers are busy after %v, dropping requests\n",
+				p.maxWorkers, time.Since(start).Truncate(time.Second))
+		}
+	}
+}
+
+// startWorker starts a worker goroutine and records the peak worker count
+func (p *Pool) startWorker(ctx context.Context) {
+	p.workersWG.Add(1)
+	active := p.activeWorkers.Add(1)
+	for {
+		peak := p.peakWorkers.Load()
+		if active <= peak || p.peakWorkers.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+
+	go p.worker(ctx, p.newRecorder())
+}
+
+// newRecorder creates a recorder for a worker and registers it with the pool
+func (p *Pool) newRecorder() *recorder {
+	rec := newRecorder(len(p.config.Endpoints), p.phaseClient != nil)
+	if p.warmup > 0 {
+		rec.warmup = newEndpointRecorders(len(p.config.Endpoints), p.phaseClient != nil)
+	}
+	p.mu.Lock()
+	rec.worker = len(p.recorders)
+	p.recorders = append(p.recorders, rec)
+	p.mu.Unlock()
+	return rec
+}
+
+// retireWorker claims an exit for an idle worker if the pool is above its
+// minimum size
+func (p *Pool) retireWorker() bool {
+	for {
+		active := p.activeWorkers.Load()
+		if active <= int64(p.workers) {
+			return false
+		}
+		if p.activeWorkers.CompareAndSwap(active, active-1) {
+			return true
+		}
+	}
+}
+
+// startVirtualUsers runs each worker as a virtual user in the closed workload
+// model. Load is bounded by the number of users and their think time rather
+// than by a target rate, so the rate limiter and load pattern are not used.
+func (p *Pool) startVirtualUsers(ctx context.Context) {
+	p.workersWG.Add(p.workers)
+	p.activeWorkers.Store(int64(p.workers))
+	p.peakWorkers.Store(int64(p.workers))
+
+	seed := time.Now().UnixNano()
+	for i := 0; i < p.workers; i++ {
+		go p.virtualUser(ctx, p.newRecorder(), newThinkTimer(p.config.ThinkTime, seed+int64(i)))
+	}
+}
+
+// virtualUser loops over sending a request and thinking until the test ends
+func (p *Pool) virtualUser(ctx context.Context, rec *recorder, think *thinkTimer) {
+	defer p.workersWG.Done()
+	defer p.activeWorkers.Add(-1)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopChan:
+			return
+		default:
+		}
+
+		if resume := p.paused(); resume != nil {
 			select {
 			case <-ctx.Done():
 				return
 			case <-p.stopChan:
 				return
-			case <-ticker.C:
-				select {
-				case <-p.stopChan:
-					return
-				case <-ctx.Done():
-					return
-				case p.jobs <- struct{}{}:
-					// Job sent successfully
-				default:
-					// Channel is full, skip this job
-					fmt.Printf("Warning: Worker pool is at capacity, skipping request\n")
-				}
+			case <-resume:
 			}
+			continue
 		}
-	}()
+
+		// A virtual user sends as soon as it has finished thinking, so the
+		// request is never behind schedule
+		j := job{intended: time.Now()}
+		p.scheduled.add(p.isWarmup(j.intended), 1)
+		p.executeRequest(ctx, rec, j)
+
+		pause := think.Next()
+		if pause <= 0 {
+			continue
+		}
+
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-p.stopChan:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// warmUp waits out the warmup, then moves to the load pattern's first stage
+// and starting rate. It returns false if the test ended first.
+func (p *Pool) warmUp(ctx context.Context) bool {
+	if p.warmup <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(time.Until(p.warmupEnd))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+
+	if p.config.Model != "closed" {
+		p.updateRate(initialRPS(p.config))
+	}
+	p.setStage(p.config.InitialStage())
+	return true
 }
 
-// controlLoadPattern manages the load pattern based on configuration
+// setStage records the load stage the load pattern has moved to
+func (p *Pool) setStage(stage string) {
+	p.mu.Lock()
+	p.prevStage, p.stage, p.stageSince = p.stage, stage, time.Now()
+	p.mu.Unlock()
+}
+
+// updateRate sets the rate the load pattern asks for, unless the control API
+// has taken the rate over. While paused the rate takes effect on resume.
+func (p *Pool) updateRate(rps int) {
+	p.control.Lock()
+	defer p.control.Unlock()
+	if p.manualRate {
+		return
+	}
+	p.targetRPS = rps
+	if p.resume == nil {
+		p.rateLimiter.UpdateRate(rps)
+	}
+}
+
+// controlLoadPattern manages the load pattern based on configuration. A stage
+// set through the control API restarts the pattern's schedule from the start
+// of that stage.
 func (p *Pool) controlLoadPattern(ctx context.Context) {
 	defer p.wg.Done()
 
-	if p.config.LoadPattern.Type != "ramp-up" {
+	if !p.warmUp(ctx) {
 		return
 	}
 
+	step := 1
+	for {
+		var stage string
+		switch p.config.LoadPattern.Type {
+		case "ramp-up":
+			stage = p.rampUp(ctx, step)
+		case "curve":
+			stage = p.followCurve(ctx)
+		default:
+			stage = p.awaitStage(ctx)
+		}
+		if stage == "" {
+			return
+		}
+		step, _ = config.RampUpStep(stage)
+	}
+}
+
+// rampUp increases the rate by the configured increment every interval,
+// starting from the given step. It returns a stage set through the control
+// API, or "" once the test is over.
+func (p *Pool) rampUp(ctx context.Context, step int) string {
 	ticker := time.NewTicker(p.config.LoadPattern.Interval)
 	defer ticker.Stop()
 
-	currentRPS := p.config.LoadPattern.StartRPS
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return ""
+		case stage := <-p.stages:
+			return stage
 		case <-ticker.C:
-			currentRPS += p.config.LoadPattern.Increment
-			if currentRPS > p.config.MaxRPS {
-				currentRPS = p.config.MaxRPS
-			}
-			p.rateLimiter.UpdateRate(currentRPS)
+			step++
+			p.updateRate(rampUpRPS(p.config, step))
+			p.setStage(config.RampUpStage(step))
 		}
 	}
 }
 
-// worker processes requests from the jobs channel
-func (p *Pool) worker(ctx context.Context) {
-	defer p.wg.Done()
+// rampUpRPS returns the rate of a step of a ramp-up, counting from 1
+func rampUpRPS(cfg *config.Config, step int) int {
+	rps := cfg.LoadPattern.StartRPS + (step-1)*cfg.LoadPattern.Increment
+	return clampRPS(float64(rps), cfg.MaxRPS)
+}
+
+// stageRPS returns the rate a stage of the load pattern starts at, after any
+// warmup
+func stageRPS(cfg *config.Config, stage string) int {
+	if step, ok := config.RampUpStep(stage); ok {
+		return rampUpRPS(cfg, step)
+	}
+	return initialRPS(cfg)
+}
+
+// followCurve sets the rate from the configured curve, interpolated at every
+// interval. It returns a stage set through the control API, or "" once the
+// test is over.
+func (p *Pool) followCurve(ctx context.Context) string {
+	interval := p.config.LoadPattern.Interval
+	if interval <= 0 {
+		interval = curveUpdateInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case stage := <-p.stages:
+			return stage
+		case <-ticker.C:
+			rps := curveRate(&p.config.LoadPattern.Curve, time.Since(start))
+			p.updateRate(clampRPS(rps, p.config.MaxRPS))
+		}
+	}
+}
+
+// awaitStage waits for a stage set through the control API, for a constant
+// rate that has no schedule of its own. It returns "" once the test is over.
+func (p *Pool) awaitStage(ctx context.Context) string {
+	select {
+	case <-ctx.Done():
+		return ""
+	case stage := <-p.stages:
+		return stage
+	}
+}
+
+// worker processes requests from the jobs channel. A worker that stays idle
+// for the idle timeout exits if the pool is above its minimum size.
+func (p *Pool) worker(ctx context.Context, rec *recorder) {
+	defer p.workersWG.Done()
+
+	retired := false
+	defer func() {
+		if !retired {
+			p.activeWorkers.Add(-1)
+		}
+	}()
+
+	var timer *time.Timer
+	var idle <-chan time.Time
+	if p.maxWorkers > p.workers {
+		timer = time.NewTimer(p.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
 
 	for {
 		select {
@@ -121,24 +568,46 @@ func (p *Pool) worker(ctx context.Context) {
 			return
 		case <-p.stopChan:
 			return
-		case _, ok := <-p.jobs:
+		case j, ok := <-p.jobs:
 			if !ok {
 				return
 			}
-			if err := p.rateLimiter.Wait(ctx); err != nil {
+			// Nothing new is sent once the test is stopping
+			if !p.issuing(ctx) {
+				p.cancelled.add(p.isWarmup(j.intended), 1)
+				return
+			}
+			p.executeRequest(ctx, rec, j)
+			if timer != nil {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(p.idleTimeout)
+			}
+		case <-idle:
+			if p.retireWorker() {
+				retired = true
 				return
 			}
-			p.executeRequest(ctx)
+			timer.Reset(p.idleTimeout)
 		}
 	}
 }
 
 // executeRequest performs a single request and updates metrics
-func (p *Pool) executeRequest(ctx context.Context) {
+func (p *Pool) executeRequest(ctx context.Context, rec *recorder, j job) {
 	start := time.Now()
 
 	// Select a random endpoint
-	endpoint := p.config.Endpoints[rand.Intn(len(p.config.Endpoints))]
+	index := rand.Intn(len(p.config.Endpoints))
+	endpoint := p.config.Endpoints[index]
+	res := result{endpoint: index, start: start, warmup: p.isWarmup(j.intended)}
+	if !p.begin(res.warmup) {
+		return
+	}
 
 	// Create request
 	req := fasthttp.AcquireRequest()
@@ -153,7 +622,8 @@ func (p *Pool) executeRequest(ctx context.Context) {
 	// Process and set headers
 	headers, err := p.processor.ProcessMap(endpoint.Headers)
 	if err != nil {
-		p.updateMetrics(start, false)
+		res.fail(ErrorTemplate, err.Error())
+		p.updateMetrics(rec, j, res)
 		return
 	}
 	for k, v := range headers {
@@ -164,7 +634,8 @@ func (p *Pool) executeRequest(ctx context.Context) {
 	if len(endpoint.QueryParams) > 0 {
 		queryParams, err := p.processor.ProcessMap(endpoint.QueryParams)
 		if err != nil {
-			p.updateMetrics(start, false)
+			res.fail(ErrorTemplate, err.Error())
+			p.updateMetrics(rec, j, res)
 			return
 		}
 		q := req.URI().QueryArgs()
@@ -177,88 +648,250 @@ func (p *Pool) executeRequest(ctx context.Context) {
 	if endpoint.Body != nil {
 		bodyBytes, err := json.Marshal(endpoint.Body)
 		if err != nil {
-			p.updateMetrics(start, false)
+			res.fail(ErrorBodyBuild, err.Error())
+			p.updateMetrics(rec, j, res)
 			return
 		}
 		bodyStr := string(bodyBytes)
 		processedBody, err := p.processor.ProcessTemplate(bodyStr, nil)
 		if err != nil {
-			p.updateMetrics(start, false)
+			res.fail(ErrorTemplate, err.Error())
+			p.updateMetrics(rec, j, res)
 			return
 		}
 		req.SetBodyString(processedBody)
 	}
 
+	var span tracing.SpanContext
+	if p.tracer != nil {
+		span = p.tracer.Start()
+		req.Header.Set(tracing.TraceparentHeader, span.Traceparent())
+	}
+
 	// Execute request
-	err = p.client.Do(req, resp)
-	success := err == nil && resp.StatusCode() >= 200 && resp.StatusCode() < 300
+	p.inFlight.Add(1)
+	switch {
+	case p.phaseClient != nil:
+		res.phases, err = p.phaseClient.do(req, resp)
+	case p.config.Timeout > 0:
+		err = p.client.DoTimeout(req, resp, p.config.Timeout)
+	default:
+		err = p.client.Do(req, resp)
+	}
+	p.inFlight.Add(-1)
+	if p.requestLog != nil {
+		res.bytesOut = int64(len(req.Header.Header()) + len(req.Body()))
+		res.bytesIn = int64(len(resp.Header.Header()) + len(resp.Body()))
+	}
+	if err != nil {
+		res.fail(classifyError(err), err.Error())
+	} else {
+		res.status = resp.StatusCode()
+		res.success = true
+		if class, message := checkResponse(&endpoint.Assert, resp); class != "" {
+			res.fail(class, message)
+		}
+	}
 
-	p.updateMetrics(start, success)
+	res.span = span
+	p.updateMetrics(rec, j, res)
 }
 
-// updateMetrics updates the metrics with the request results
-func (p *Pool) updateMetrics(start time.Time, success bool) {
-	duration := time.Since(start)
+// finishSpan exports the client span of a sampled request
+func (p *Pool) finishSpan(res result) {
+	endpoint := p.config.Endpoints[res.endpoint]
+	attributes := map[string]interface{}{
+		"http.request.method": endpoint.Method,
+		"url.full":            endpoint.URL,
+	}
+	if res.status != 0 {
+		attributes["http.response.status_code"] = res.status
+	}
+	if res.class != "" {
+		attributes["error.type"] = res.class
+	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.tracer.Finish(tracing.Span{
+		Context:    res.span,
+		Name:       endpoint.DisplayName(),
+		Start:      res.start,
+		End:        time.Now(),
+		Attributes: attributes,
+		Error:      res.message,
+	})
+}
 
-	p.metrics.TotalRequests++
-	if success {
-		p.metrics.SuccessfulRequests++
-	} else {
-		p.metrics.FailedRequests++
+// updateMetrics records the request results. Service time is measured from
+// when the request was actually started; response time is measured from when
+// it was scheduled, so time spent queued behind a stalled server is not hidden.
+// Results that arrive after the drain at shutdown are discarded, as the
+// request was already counted as cancelled.
+func (p *Pool) updateMetrics(rec *recorder, j job, res result) {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return
 	}
 
-	// Update latency stats
-	p.latencies = append(p.latencies, duration)
-	if len(p.latencies) > 1000 {
-		p.latencies = p.latencies[1:]
-	}
+	end := time.Now()
+	late := res.start.Sub(j.intended) > p.lateThreshold
+	rec.record(res, end.Sub(res.start), end.Sub(j.intended), late)
+	p.busy.add(res.warmup, -1)
 
-	// Update min/max
-	if p.metrics.LatencyStats.Min == 0 || duration < p.metrics.LatencyStats.Min {
-		p.metrics.LatencyStats.Min = duration
-	}
-	if duration > p.metrics.LatencyStats.Max {
-		p.metrics.LatencyStats.Max = duration
+	if res.span.Sampled {
+		p.finishSpan(res)
 	}
 
-	// Calculate mean
-	var total time.Duration
-	for _, lat := range p.latencies {
-		total += lat
+	if p.requestLog != nil {
+		p.requestLog.Write(requestlog.Record{
+			Intended: j.intended,
+			Start:    res.start,
+			Latency:  end.Sub(res.start),
+			Endpoint: res.endpoint,
+			Status:   res.status,
+			Error:    res.class,
+			BytesOut: res.bytesOut,
+			BytesIn:  res.bytesIn,
+			Worker:   rec.worker,
+		})
 	}
-	p.metrics.LatencyStats.Mean = total / time.Duration(len(p.latencies))
+}
 
-	// Calculate percentiles
-	if len(p.latencies) > 0 {
-		p.metrics.LatencyStats.P50 = p.calculatePercentile(0.5)
-		p.metrics.LatencyStats.P95 = p.calculatePercentile(0.95)
-		p.metrics.LatencyStats.P99 = p.calculatePercentile(0.99)
+// begin counts a request as in flight, unless the drain at shutdown is over,
+// in which case it is counted as cancelled. Checking and counting under
+// closeMu means Stop either finds the request in flight and cancels it, or
+// the request finds the pool closed; it cannot go uncounted in between.
+func (p *Pool) begin(warmup bool) bool {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		p.cancelled.add(warmup, 1)
+		return false
 	}
+	p.busy.add(warmup, 1)
+	return true
 }
 
-// calculatePercentile calculates the given percentile from the latency data
-func (p *Pool) calculatePercentile(percentile float64) time.Duration {
-	if len(p.latencies) == 0 {
-		return 0
-	}
+// isWarmup reports whether a request scheduled at intended belongs to the warmup
+func (p *Pool) isWarmup(intended time.Time) bool {
+	return intended.Before(p.warmupEnd)
+}
 
-	index := int(float64(len(p.latencies)-1) * percentile)
-	return p.latencies[index]
+// issuing reports whether new requests may still be sent
+func (p *Pool) issuing(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.stopChan:
+		return false
+	default:
+		return true
+	}
 }
 
-// Stop gracefully shuts down the worker pool
+// Stop shuts down the worker pool. It stops issuing requests, counts the jobs
+// still queued as cancelled, and waits up to the drain timeout for requests
+// in flight to finish. Those still running after that are counted as
+// cancelled too, so once Stop returns every scheduled request is either
+// recorded, dropped or cancelled.
 func (p *Pool) Stop() {
-	close(p.stopChan) // Signal all goroutines to stop
-	p.wg.Wait()       // Wait for all goroutines to finish
-	close(p.jobs)     // Close the jobs channel after all workers are done
+	close(p.stopChan) // Stop issuing requests
+	if p.cancel != nil {
+		p.cancel() // Wake the dispatcher if it is waiting on the rate limiter
+	}
+	p.wg.Wait() // Wait for the dispatcher, controller and sampler to exit
+	stopped := time.Now()
+
+	// The dispatcher has exited, so nothing more is queued. Workers may
+	// take queued jobs concurrently, but they cancel them too.
+	for queued := true; queued; {
+		select {
+		case j := <-p.jobs:
+			p.cancelled.add(p.isWarmup(j.intended), 1)
+		default:
+			queued = false
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		p.workersWG.Wait()
+		close(drained)
+	}()
+	timer := time.NewTimer(p.drainTimeout)
+	select {
+	case <-drained:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	// Requests still running are abandoned: their results will be discarded
+	p.closeMu.Lock()
+	p.closed = true
+	p.cancelled.all.Add(p.busy.all.Load())
+	p.cancelled.warmup.Add(p.busy.warmup.Load())
+	p.closeMu.Unlock()
+
+	// Nothing more is logged once the pool is closed, so the run's span can
+	// follow the records and replay can measure it as the run did
+	if !p.started.IsZero() {
+		p.requestLog.End(p.started, stopped)
+	}
+	p.closeInterval(time.Now(), true)
+	p.mu.Lock()
+	p.stopped = stopped
+	p.mu.Unlock()
 }
 
-// GetMetrics returns the current metrics
+// GetMetrics returns a snapshot of the current metrics, merged from the
+// recorders of every worker
 func (p *Pool) GetMetrics() *config.Metrics {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.metrics
+	recorders := append([]*recorder(nil), p.recorders...)
+	series := append([]config.IntervalMetrics(nil), p.series...)
+	stage := p.stage
+	end := p.stopped
+	p.mu.Unlock()
+
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	// Measurement starts once the warmup is over
+	var elapsed, warmupElapsed time.Duration
+	if !p.started.IsZero() {
+		elapsed = end.Sub(p.started)
+		warmupElapsed = p.warmup
+		if elapsed < warmupElapsed {
+			warmupElapsed = elapsed
+		}
+		elapsed -= warmupElapsed
+	}
+
+	m := &config.Metrics{
+		ScheduledRequests: p.scheduled.measured(),
+		DroppedRequests:   p.dropped.measured(),
+		CancelledRequests: p.cancelled.measured(),
+		ActiveWorkers:     p.activeWorkers.Load(),
+		PeakWorkers:       p.peakWorkers.Load(),
+		InFlight:          p.inFlight.Load(),
+		Paused:            p.paused() != nil,
+		Stage:             stage,
+		TimeSeries:        series,
+	}
+	if p.config.Model != "closed" {
+		m.TargetRPS = p.rateLimiter.Rate()
+	}
+	m.CurrentRPS = currentRPS(series)
+	snapshot(m, recorders, p.config.Endpoints, elapsed, false)
+	if p.warmup > 0 {
+		m.Warmup = &config.Metrics{
+			ScheduledRequests: p.scheduled.warmup.Load(),
+			DroppedRequests:   p.dropped.warmup.Load(),
+			CancelledRequests: p.cancelled.warmup.Load(),
+		}
+		snapshot(m.Warmup, recorders, p.config.Endpoints, warmupElapsed, true)
+	}
+
+	return m
 }
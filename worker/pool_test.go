@@ -2,17 +2,27 @@ package worker
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"protobuf/config"
+	"protobuf/mock"
+	"protobuf/requestlog"
+	"protobuf/tracing"
+
+	"github.com/valyala/fasthttp"
 )
 
 func TestPool_ExecuteRequest(t *testing.T) {
 	cfg := &config.Config{
 		Endpoints: []config.Endpoint{
 			{
-				URL:    "https://jsonplaceholder.typicode.com/posts/1",
+				URL:    newTestServer(t, postsRoute) + "/posts/1",
 				Method: "GET",
 				Headers: map[string]string{
 					"Content-Type": "application/json",
@@ -69,7 +79,7 @@ func TestPool_RateLimiting(t *testing.T) {
 	cfg := &config.Config{
 		Endpoints: []config.Endpoint{
 			{
-				URL:    "https://jsonplaceholder.typicode.com/posts/1",
+				URL:    newTestServer(t, postsRoute) + "/posts/1",
 				Method: "GET",
 				Headers: map[string]string{
 					"Content-Type": "application/json",
@@ -113,7 +123,7 @@ func TestPool_LoadPattern(t *testing.T) {
 	cfg := &config.Config{
 		Endpoints: []config.Endpoint{
 			{
-				URL:    "https://jsonplaceholder.typicode.com/posts/1",
+				URL:    newTestServer(t, postsRoute) + "/posts/1",
 				Method: "GET",
 				Headers: map[string]string{
 					"Content-Type": "application/json",
@@ -157,3 +167,805 @@ func TestPool_LoadPattern(t *testing.T) {
 		t.Errorf("Expected at least %d requests for ramp-up pattern, got %d", minExpectedRequests, metrics.TotalRequests)
 	}
 }
+
+// postsRoute serves a small JSON API under /posts/, with latency like that of
+// a real service
+var postsRoute = mock.Route{
+	Path:    "/posts/",
+	Headers: map[string]string{"Content-Type": "application/json"},
+	Body:    `{"id":1,"title":"mock post"}`,
+	Latency: config.ThinkTime{Distribution: "normal", Mean: 20 * time.Millisecond, StdDev: 5 * time.Millisecond},
+}
+
+// newTestServer starts a mock target for the routes, or one that answers
+// every request at once with 200 OK when none are given
+func newTestServer(t *testing.T, routes ...mock.Route) string {
+	t.Helper()
+	if len(routes) == 0 {
+		routes = []mock.Route{{Path: "/", Body: `{"ok":true}`}}
+	}
+	s, err := mock.NewServer(&mock.Config{Routes: routes})
+	if err != nil {
+		t.Fatalf("Error creating mock server: %v", err)
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// runPool runs a pool against cfg for the given duration and returns its metrics
+func runPool(t *testing.T, workers int, cfg *config.Config, duration time.Duration) *config.Metrics {
+	t.Helper()
+	pool := NewPool(workers, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	pool.Start(ctx)
+	<-ctx.Done()
+	pool.Stop()
+
+	return pool.GetMetrics()
+}
+
+func TestPool_AchievedRPSTracksProfile(t *testing.T) {
+	url := newTestServer(t)
+	endpoints := []config.Endpoint{{URL: url, Method: "GET"}}
+
+	tests := []struct {
+		name     string
+		pattern  config.LoadPattern
+		maxRPS   int
+		duration time.Duration
+		expected int64
+	}{
+		{
+			name:     "constant",
+			pattern:  config.LoadPattern{Type: "constant", StartRPS: 50},
+			duration: 2 * time.Second,
+			expected: 100,
+		},
+		{
+			// 20 + 40 + 60 + 80 over four one-second steps
+			name:     "ramp-up",
+			pattern:  config.LoadPattern{Type: "ramp-up", StartRPS: 20, Increment: 20, Interval: time.Second},
+			maxRPS:   100,
+			duration: 4 * time.Second,
+			expected: 200,
+		},
+		{
+			// Capped at max_rps after the second step: 20 + 40 + 40 + 40
+			name:     "ramp-up capped",
+			pattern:  config.LoadPattern{Type: "ramp-up", StartRPS: 20, Increment: 20, Interval: time.Second},
+			maxRPS:   40,
+			duration: 4 * time.Second,
+			expected: 140,
+		},
+		{
+			// Linear from 20 to 100 RPS over three seconds averages 60 RPS
+			name: "curve",
+			pattern: config.LoadPattern{Type: "curve", Curve: config.Curve{
+				Points: []config.CurvePoint{{Seconds: 0, RPS: 20}, {Seconds: 3, RPS: 100}},
+			}},
+			duration: 3 * time.Second,
+			expected: 180,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Endpoints:   endpoints,
+				LoadPattern: tt.pattern,
+				MaxRPS:      tt.maxRPS,
+			}
+
+			metrics := runPool(t, 20, cfg, tt.duration)
+
+			tolerance := tt.expected / 10
+			if metrics.TotalRequests < tt.expected-tolerance || metrics.TotalRequests > tt.expected+tolerance {
+				t.Errorf("Expected %d±%d requests, got %d", tt.expected, tolerance, metrics.TotalRequests)
+			}
+		})
+	}
+}
+
+func TestPool_ClosedModel(t *testing.T) {
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{{URL: newTestServer(t), Method: "GET"}},
+		Model:     "closed",
+		ThinkTime: config.ThinkTime{Distribution: "fixed", Mean: 100 * time.Millisecond},
+	}
+
+	// Five users each completing a request every ~100ms for two seconds
+	metrics := runPool(t, 5, cfg, 2*time.Second)
+
+	if metrics.TotalRequests < 80 || metrics.TotalRequests > 105 {
+		t.Errorf("Expected around 100 requests from 5 virtual users, got %d", metrics.TotalRequests)
+	}
+	if metrics.FailedRequests != 0 {
+		t.Errorf("Expected no failed requests, got %d", metrics.FailedRequests)
+	}
+}
+
+func TestPool_CoordinatedOmission(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints:   []config.Endpoint{{URL: server.URL, Method: "GET"}},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 20},
+	}
+
+	// A single worker can manage 5 RPS against this server, so most of the
+	// 20 RPS schedule is either sent late or dropped.
+	metrics := runPool(t, 1, cfg, 2*time.Second)
+
+	if metrics.DroppedRequests == 0 {
+		t.Error("Expected dropped requests to be counted")
+	}
+	if metrics.LateRequests == 0 {
+		t.Error("Expected late requests to be counted")
+	}
+	if metrics.ResponseTimeStats.Max <= metrics.LatencyStats.Max {
+		t.Errorf("Expected response time (%v) to include the wait behind the stalled server beyond service time (%v)",
+			metrics.ResponseTimeStats.Max, metrics.LatencyStats.Max)
+	}
+}
+
+func TestPool_AdaptiveConcurrency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	// 100 RPS against a 100ms server needs about 10 workers; after a second
+	// the rate drops to 5 RPS and the extra workers should go idle and exit.
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{{URL: server.URL, Method: "GET"}},
+		LoadPattern: config.LoadPattern{Type: "curve", Curve: config.Curve{
+			Points: []config.CurvePoint{{Seconds: 0, RPS: 100}, {Seconds: 1, RPS: 100}, {Seconds: 1.1, RPS: 5}},
+		}},
+		Concurrency: config.Concurrency{Min: 2, Max: 30, IdleTimeout: 300 * time.Millisecond},
+	}
+
+	pool := NewPool(100, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool.Start(ctx)
+	time.Sleep(3 * time.Second)
+	active := pool.GetMetrics().ActiveWorkers
+	pool.Stop()
+
+	metrics := pool.GetMetrics()
+	if metrics.PeakWorkers < 8 || metrics.PeakWorkers > 30 {
+		t.Errorf("Expected the pool to grow to about 10 workers, peaked at %d", metrics.PeakWorkers)
+	}
+	if metrics.DroppedRequests != 0 {
+		t.Errorf("Expected no dropped requests below the maximum, got %d", metrics.DroppedRequests)
+	}
+	if active >= metrics.PeakWorkers {
+		t.Errorf("Expected idle workers to exit after the rate dropped, %d of %d still active", active, metrics.PeakWorkers)
+	}
+}
+
+func TestPool_EndpointMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{
+			{Name: "slow", URL: server.URL + "/slow", Method: "GET"},
+			{Name: "broken", URL: server.URL + "/broken", Method: "GET"},
+		},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 50},
+	}
+
+	metrics := runPool(t, 10, cfg, 2*time.Second)
+
+	if len(metrics.Endpoints) != 2 {
+		t.Fatalf("Expected metrics for 2 endpoints, got %d", len(metrics.Endpoints))
+	}
+	slow, broken := metrics.Endpoints[0], metrics.Endpoints[1]
+	if slow.Name != "slow" || broken.Name != "broken" {
+		t.Errorf("Expected endpoints in config order, got %q and %q", slow.Name, broken.Name)
+	}
+
+	if slow.TotalRequests+broken.TotalRequests != metrics.TotalRequests {
+		t.Errorf("Expected endpoint totals to add up to %d, got %d + %d",
+			metrics.TotalRequests, slow.TotalRequests, broken.TotalRequests)
+	}
+	if slow.FailedRequests != 0 {
+		t.Errorf("Expected no failures for the slow endpoint, got %d", slow.FailedRequests)
+	}
+	if broken.SuccessfulRequests != 0 || broken.Errors[ErrorHTTPStatus].Count != broken.TotalRequests {
+		t.Errorf("Expected every broken request to fail with %s, got %v", ErrorHTTPStatus, broken.Errors)
+	}
+	if broken.StatusCodes[500] != broken.TotalRequests || slow.StatusCodes[200] != slow.TotalRequests {
+		t.Errorf("Expected status codes per endpoint, got %v and %v", slow.StatusCodes, broken.StatusCodes)
+	}
+	if slow.LatencyStats.P50 < 50*time.Millisecond || broken.LatencyStats.P50 >= 50*time.Millisecond {
+		t.Errorf("Expected separate latency distributions, got p50 %v (slow) and %v (broken)",
+			slow.LatencyStats.P50, broken.LatencyStats.P50)
+	}
+	if slow.RPS <= 0 || broken.RPS <= 0 {
+		t.Error("Expected per-endpoint throughput")
+	}
+}
+
+func TestPool_TimeSeries(t *testing.T) {
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{{URL: newTestServer(t), Method: "GET"}},
+		LoadPattern: config.LoadPattern{
+			Type:      "ramp-up",
+			StartRPS:  20,
+			Increment: 20,
+			Interval:  time.Second,
+		},
+		MetricsInterval: 500 * time.Millisecond,
+	}
+
+	metrics := runPool(t, 5, cfg, 3*time.Second)
+
+	series := metrics.TimeSeries
+	if len(series) < 6 || len(series) > 7 {
+		t.Fatalf("Expected 6 intervals over 3s, got %d", len(series))
+	}
+
+	var requests int64
+	for i, interval := range series {
+		requests += interval.Requests
+		if i > 0 && interval.Start != series[i-1].Start+series[i-1].Duration {
+			t.Errorf("Interval %d starts at %v, want %v", i, interval.Start, series[i-1].Start+series[i-1].Duration)
+		}
+		if interval.ErrorRate != 0 {
+			t.Errorf("Interval %d has error rate %v, want 0", i, interval.ErrorRate)
+		}
+	}
+	if requests != metrics.TotalRequests {
+		t.Errorf("Expected interval requests to add up to %d, got %d", metrics.TotalRequests, requests)
+	}
+
+	first, last := series[0], series[4] // ending at 0.5s and 2.5s
+	if first.TargetRPS != 20 || last.TargetRPS != 60 {
+		t.Errorf("Expected target to ramp from 20 to 60 RPS, got %v and %v", first.TargetRPS, last.TargetRPS)
+	}
+	if last.AchievedRPS < 40 || last.AchievedRPS > 80 {
+		t.Errorf("Expected about 60 RPS achieved at the end of the ramp, got %v", last.AchievedRPS)
+	}
+	if last.P50 == 0 || last.P99 < last.P50 {
+		t.Errorf("Expected interval percentiles, got p50 %v p99 %v", last.P50, last.P99)
+	}
+	full := series[len(series)-1]
+	if full.Partial {
+		full = series[len(series)-2]
+	}
+	if full.Partial || metrics.CurrentRPS != full.AchievedRPS {
+		t.Errorf("Expected CurrentRPS to come from the last full interval, got %v", metrics.CurrentRPS)
+	}
+	for i, interval := range series[:len(series)-1] {
+		if interval.Partial {
+			t.Errorf("Interval %d is marked partial, only the last one can be", i)
+		}
+	}
+}
+
+func TestPool_TracePropagation(t *testing.T) {
+	headers := make(chan string, 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get(tracing.TraceparentHeader)
+		if r.URL.Path == "/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{
+			{Name: "fast", URL: server.URL, Method: "GET"},
+			{Name: "slow", URL: server.URL + "/slow", Method: "GET"},
+		},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 100},
+		Tracing:     config.Tracing{Enabled: true, SampleRatio: 1},
+	}
+	tracer, err := tracing.NewTracer(cfg.Tracing)
+	if err != nil {
+		t.Fatalf("NewTracer returned error: %v", err)
+	}
+	defer tracer.Close()
+
+	pool := NewPool(5, cfg)
+	pool.SetTracer(tracer)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pool.Start(ctx)
+	<-ctx.Done()
+	pool.Stop()
+	close(headers)
+
+	for header := range headers {
+		if !strings.HasPrefix(header, "00-") || !strings.HasSuffix(header, "-01") {
+			t.Fatalf("Expected a sampled traceparent on every request, got %q", header)
+		}
+	}
+
+	slowest := pool.GetMetrics().SlowestTraced
+	if len(slowest) != 10 {
+		t.Fatalf("Expected the 10 slowest traced requests, got %d", len(slowest))
+	}
+	for i, r := range slowest {
+		if r.Endpoint != "slow" || len(r.TraceID) != 32 {
+			t.Errorf("Expected slow endpoint requests with trace IDs, got %+v", r)
+		}
+		if i > 0 && r.ResponseTime > slowest[i-1].ResponseTime {
+			t.Errorf("Expected slowest first, got %v after %v", r.ResponseTime, slowest[i-1].ResponseTime)
+		}
+	}
+}
+
+func TestPool_PhaseTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{
+			{Name: "posts", URL: strings.Replace(server.URL, "127.0.0.1", "localhost", 1), Method: "POST",
+				Body: map[string]string{"title": "x"}, Assert: config.Assertions{BodyContains: "id"}},
+		},
+		LoadPattern:  config.LoadPattern{Type: "constant", StartRPS: 50},
+		PhaseTimings: true,
+	}
+	m := runPool(t, 5, cfg, time.Second)
+
+	if m.TotalRequests == 0 || m.FailedRequests != 0 {
+		t.Fatalf("Expected successful requests, got %d of %d failed: %v", m.FailedRequests, m.TotalRequests, m.Errors)
+	}
+	if m.Phases == nil || m.Endpoints[0].Phases == nil {
+		t.Fatal("Expected phase timings when phase_timings is set")
+	}
+
+	p := m.Phases
+	if n := p.TTFB.Histogram.Count(); n != m.TotalRequests {
+		t.Errorf("Expected time to first byte for all %d requests, got %d", m.TotalRequests, n)
+	}
+	if p.TTFB.P50 < 10*time.Millisecond {
+		t.Errorf("Expected time to first byte to include the server's 10ms, got %v", p.TTFB.P50)
+	}
+	if n := p.Connect.Histogram.Count(); n == 0 || n > m.TotalRequests {
+		t.Errorf("Expected between 1 and %d new connections, got %d", m.TotalRequests, n)
+	}
+	if p.DNS.Histogram.Count() == 0 {
+		t.Error("Expected localhost to be resolved")
+	}
+	if p.TLS.Histogram.Count() != 0 {
+		t.Error("Expected no TLS handshakes over plain HTTP")
+	}
+	if p.Write.Histogram.Count() != m.TotalRequests || p.Transfer.Histogram.Count() != m.TotalRequests {
+		t.Errorf("Expected write and transfer timings for every request, got %d and %d",
+			p.Write.Histogram.Count(), p.Transfer.Histogram.Count())
+	}
+
+	cfg.PhaseTimings = false
+	if m := runPool(t, 5, cfg, 200*time.Millisecond); m.Phases != nil {
+		t.Error("Expected no phase timings when phase_timings is unset")
+	}
+}
+
+func TestPhaseClient_MatchesFastHTTP(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		headers = r.Header.Clone()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := newPhaseClient(time.Second)
+	send := func(path string) *fasthttp.Response {
+		t.Helper()
+		req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(server.URL + path)
+		req.Header.Set("Connection", "close")
+		if _, err := client.do(req, resp); err != nil {
+			t.Fatalf("do returned error: %v", err)
+		}
+		return resp
+	}
+
+	// Redirects are returned, not followed, as fasthttp does
+	if resp := send("/old"); resp.StatusCode() != http.StatusFound {
+		t.Errorf("Expected the redirect itself, got %d", resp.StatusCode())
+	}
+
+	// No compression is asked for, and framing headers are left to net/http
+	send("/new")
+	if headers.Get("Accept-Encoding") != "" {
+		t.Errorf("Expected no Accept-Encoding, got %q", headers.Get("Accept-Encoding"))
+	}
+	if headers.Get("Connection") != "" || headers.Get("Host") != "" {
+		t.Errorf("Expected the framing headers not to be copied, got %v", headers)
+	}
+}
+
+func TestPool_RequestLogReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{
+			{Name: "ok", URL: server.URL, Method: "GET"},
+			{Name: "missing", URL: server.URL + "/missing", Method: "GET"},
+		},
+		LoadPattern:     config.LoadPattern{Type: "constant", StartRPS: 200},
+		Duration:        1500 * time.Millisecond,
+		MetricsInterval: 500 * time.Millisecond,
+	}
+	path := filepath.Join(t.TempDir(), "requests.bin")
+	log, err := requestlog.Create(path, requestlog.Header{
+		Started:         time.Now(),
+		Duration:        cfg.Duration,
+		Endpoints:       []string{"ok", "missing"},
+		MetricsInterval: cfg.MetricsInterval,
+	})
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	pool := NewPool(5, cfg)
+	pool.SetRequestLog(log)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
+	defer cancel()
+	pool.Start(ctx)
+	<-ctx.Done()
+	pool.Stop()
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	live := pool.GetMetrics()
+
+	reader, err := requestlog.Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer reader.Close()
+	replayed, err := Replay(reader)
+	if err != nil {
+		t.Fatalf("Replay returned error: %v", err)
+	}
+
+	if replayed.TotalRequests != live.TotalRequests || replayed.FailedRequests != live.FailedRequests ||
+		replayed.LateRequests != live.LateRequests {
+		t.Errorf("Expected %d requests, %d failed and %d late, got %d, %d and %d",
+			live.TotalRequests, live.FailedRequests, live.LateRequests,
+			replayed.TotalRequests, replayed.FailedRequests, replayed.LateRequests)
+	}
+	if replayed.StatusCodes[404] != live.StatusCodes[404] || replayed.Errors[ErrorHTTPStatus].Count != live.Errors[ErrorHTTPStatus].Count {
+		t.Errorf("Expected %d not found responses, got %d", live.StatusCodes[404], replayed.StatusCodes[404])
+	}
+	if replayed.ResponseTimeStats.P99 != live.ResponseTimeStats.P99 || replayed.LatencyStats.Max != live.LatencyStats.Max {
+		t.Errorf("Expected the same latencies, got p99 %v and max %v, want %v and %v",
+			replayed.ResponseTimeStats.P99, replayed.LatencyStats.Max, live.ResponseTimeStats.P99, live.LatencyStats.Max)
+	}
+	if len(replayed.Endpoints) != 2 || replayed.Endpoints[1].TotalRequests != live.Endpoints[1].TotalRequests {
+		t.Errorf("Expected the per-endpoint breakdown to be rebuilt, got %+v", replayed.Endpoints)
+	}
+	// The log's footer times the run as the pool did, rather than up to the
+	// last response
+	if d := replayed.Elapsed - live.Elapsed; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("Expected the run to take %v as it did live, got %v", live.Elapsed, replayed.Elapsed)
+	}
+	if len(replayed.TimeSeries) != 3 {
+		t.Fatalf("Expected 3 intervals, got %d", len(replayed.TimeSeries))
+	}
+	var requests int64
+	for _, i := range replayed.TimeSeries {
+		requests += i.Requests
+	}
+	if requests != live.TotalRequests {
+		t.Errorf("Expected the time series to hold all %d requests, got %d", live.TotalRequests, requests)
+	}
+}
+
+func TestPool_Warmup(t *testing.T) {
+	url := newTestServer(t)
+	cfg := &config.Config{
+		Endpoints:       []config.Endpoint{{Name: "posts", URL: url, Method: "GET"}},
+		LoadPattern:     config.LoadPattern{Type: "constant", StartRPS: 100},
+		Warmup:          config.Warmup{Duration: time.Second, RPS: 20},
+		MetricsInterval: 500 * time.Millisecond,
+	}
+	m := runPool(t, 5, cfg, 2*time.Second)
+
+	if m.Warmup == nil {
+		t.Fatal("Expected warmup metrics")
+	}
+	if n := m.Warmup.TotalRequests; n < 15 || n > 25 {
+		t.Errorf("Expected about 20 warmup requests at the warmup rate, got %d", n)
+	}
+	if m.Warmup.Elapsed != time.Second {
+		t.Errorf("Expected a 1s warmup, got %v", m.Warmup.Elapsed)
+	}
+	if n := m.TotalRequests; n < 90 || n > 110 {
+		t.Errorf("Expected about 100 measured requests, got %d", n)
+	}
+	if m.Elapsed < 900*time.Millisecond || m.Elapsed > 1100*time.Millisecond {
+		t.Errorf("Expected the measurement to cover the 1s after warmup, got %v", m.Elapsed)
+	}
+	if m.AchievedRPS < 85 || m.AchievedRPS > 115 {
+		t.Errorf("Expected about 100 RPS over the measurement, got %.1f", m.AchievedRPS)
+	}
+
+	if len(m.TimeSeries) < 4 {
+		t.Fatalf("Expected at least 4 intervals, got %d", len(m.TimeSeries))
+	}
+	var series int64
+	for i, interval := range m.TimeSeries {
+		series += interval.Requests
+		want := "constant"
+		if i < 2 {
+			want = "warmup"
+		}
+		if i < 4 && interval.Stage != want {
+			t.Errorf("Expected interval %d in stage %s, got %s", i, want, interval.Stage)
+		}
+	}
+	if series != m.TotalRequests+m.Warmup.TotalRequests {
+		t.Errorf("Expected the time series to cover warmup and measured requests, got %d of %d",
+			series, m.TotalRequests+m.Warmup.TotalRequests)
+	}
+
+	cfg.Warmup = config.Warmup{}
+	if m := runPool(t, 5, cfg, 200*time.Millisecond); m.Warmup != nil {
+		t.Error("Expected no warmup metrics without a warmup")
+	}
+}
+
+func TestPool_Drain(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := 20 * time.Millisecond
+		if r.URL.Path == "/slow" {
+			delay = 5 * time.Second
+		}
+		select {
+		case <-time.After(delay):
+		case <-release:
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	var once sync.Once
+	finish := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(server.Close)
+	t.Cleanup(finish)
+
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{
+			{Name: "fast", URL: server.URL + "/fast", Method: "GET"},
+			{Name: "slow", URL: server.URL + "/slow", Method: "GET"},
+		},
+		LoadPattern:  config.LoadPattern{Type: "constant", StartRPS: 100},
+		DrainTimeout: 300 * time.Millisecond,
+	}
+	pool := NewPool(10, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	pool.Start(ctx)
+	<-ctx.Done()
+	stopping := time.Now()
+	pool.Stop()
+	if d := time.Since(stopping); d > time.Second {
+		t.Errorf("Expected Stop to give up after the drain timeout, took %v", d)
+	}
+
+	m := pool.GetMetrics()
+	if m.CancelledRequests == 0 {
+		t.Error("Expected the slow requests in flight at shutdown to be cancelled")
+	}
+	if m.TotalRequests == 0 {
+		t.Error("Expected the fast requests to be recorded")
+	}
+	if got := m.TotalRequests + m.DroppedRequests + m.CancelledRequests; got != m.ScheduledRequests {
+		t.Errorf("Expected %d total, dropped and cancelled requests to add up to %d scheduled",
+			got, m.ScheduledRequests)
+	}
+	var series int64
+	for _, interval := range m.TimeSeries {
+		series += interval.Requests
+	}
+	if series != m.TotalRequests {
+		t.Errorf("Expected the time series to hold all %d requests, got %d", m.TotalRequests, series)
+	}
+
+	// Requests abandoned at shutdown must not be recorded when they finish
+	finish()
+	time.Sleep(100 * time.Millisecond)
+	if after := pool.GetMetrics(); after.TotalRequests != m.TotalRequests || after.CancelledRequests != m.CancelledRequests {
+		t.Errorf("Expected the results to be final after Stop, got %d total and %d cancelled, then %d and %d",
+			m.TotalRequests, m.CancelledRequests, after.TotalRequests, after.CancelledRequests)
+	}
+}
+
+func TestPool_StopWithoutStart(t *testing.T) {
+	pool := NewPool(2, &config.Config{
+		Endpoints:   []config.Endpoint{{URL: newTestServer(t), Method: "GET"}},
+		LoadPattern: config.LoadPattern{Type: "constant", StartRPS: 10},
+	})
+	pool.Stop()
+	if m := pool.GetMetrics(); m.TotalRequests != 0 || m.CancelledRequests != 0 {
+		t.Errorf("Expected no requests from a pool never started, got %d and %d cancelled", m.TotalRequests, m.CancelledRequests)
+	}
+}
+
+func TestPool_Control(t *testing.T) {
+	cfg := &config.Config{
+		Endpoints:       []config.Endpoint{{URL: newTestServer(t), Method: "GET"}},
+		LoadPattern:     config.LoadPattern{Type: "ramp-up", StartRPS: 20, Increment: 20, Interval: 200 * time.Millisecond},
+		MaxRPS:          500,
+		MetricsInterval: 250 * time.Millisecond,
+	}
+	pool := NewPool(5, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Start(ctx)
+
+	// Taking over the rate stops the ramp
+	if err := pool.SetRate(100); err != nil {
+		t.Fatalf("SetRate returned error: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if rate := pool.GetMetrics().TargetRPS; rate != 100 {
+		t.Errorf("Expected the set rate to hold against the ramp, got %.0f RPS", rate)
+	}
+	if err := pool.SetRate(1000); err == nil {
+		t.Error("Expected a rate above max_rps to be rejected")
+	}
+
+	if err := pool.Pause(); err != nil {
+		t.Fatalf("Pause returned error: %v", err)
+	}
+	if err := pool.Pause(); err == nil {
+		t.Error("Expected a second pause to fail")
+	}
+	time.Sleep(50 * time.Millisecond)
+	before := pool.GetMetrics()
+	time.Sleep(300 * time.Millisecond)
+	if after := pool.GetMetrics(); after.TotalRequests != before.TotalRequests || !after.Paused {
+		t.Errorf("Expected no requests while paused, went from %d to %d", before.TotalRequests, after.TotalRequests)
+	}
+
+	if err := pool.Resume(); err != nil {
+		t.Fatalf("Resume returned error: %v", err)
+	}
+	if err := pool.SetStage("after-deploy"); err == nil || !strings.Contains(err.Error(), "ramp-up step N") {
+		t.Errorf("Expected a stage the load pattern does not have to be rejected, got %v", err)
+	}
+	if err := pool.SetStage("ramp-up step 10"); err != nil {
+		t.Fatalf("SetStage returned error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	pool.Stop()
+
+	m := pool.GetMetrics()
+	if m.TargetRPS != 100 || m.Stage != "ramp-up step 10" {
+		t.Errorf("Expected to resume at 100 RPS in stage ramp-up step 10, got %.0f RPS in %s", m.TargetRPS, m.Stage)
+	}
+	var annotations []string
+	for _, interval := range m.TimeSeries {
+		for _, a := range interval.Annotations {
+			if a.At < interval.Start || a.At > interval.Start+interval.Duration {
+				t.Errorf("Annotation %q at %v is outside its interval", a.Text, a.At)
+			}
+			annotations = append(annotations, a.Text)
+		}
+	}
+	want := []string{"rate set to 100 RPS", "paused", "resumed", "stage set to ramp-up step 10"}
+	if strings.Join(annotations, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Expected annotations %v, got %v", want, annotations)
+	}
+}
+
+func TestPool_ControlOverridesAreIndependent(t *testing.T) {
+	start := func() (*Pool, context.CancelFunc) {
+		cfg := &config.Config{
+			Endpoints:   []config.Endpoint{{URL: newTestServer(t), Method: "GET"}},
+			LoadPattern: config.LoadPattern{Type: "ramp-up", StartRPS: 20, Increment: 20, Interval: 200 * time.Millisecond},
+			MaxRPS:      500,
+		}
+		pool := NewPool(5, cfg)
+		ctx, cancel := context.WithCancel(context.Background())
+		pool.Start(ctx)
+		return pool, func() {
+			cancel()
+			pool.Stop()
+		}
+	}
+
+	// Setting the stage moves the ramp to that step, and it carries on from there
+	pool, stop := start()
+	if err := pool.SetStage("ramp-up step 5"); err != nil {
+		t.Fatalf("SetStage returned error: %v", err)
+	}
+	m := pool.GetMetrics()
+	if m.Stage != "ramp-up step 5" || m.TargetRPS != 100 {
+		t.Errorf("Expected ramp-up step 5 at 100 RPS, got %s at %.0f RPS", m.Stage, m.TargetRPS)
+	}
+	time.Sleep(300 * time.Millisecond)
+	m = pool.GetMetrics()
+	stop()
+	if m.Stage != "ramp-up step 6" || m.TargetRPS != 120 {
+		t.Errorf("Expected the ramp to move on to step 6 at 120 RPS, got %s at %.0f RPS", m.Stage, m.TargetRPS)
+	}
+
+	// Setting the rate leaves the stage transitions running, even after a
+	// stage is set
+	pool, stop = start()
+	if err := pool.SetRate(50); err != nil {
+		t.Fatalf("SetRate returned error: %v", err)
+	}
+	if err := pool.SetStage("ramp-up step 5"); err != nil {
+		t.Fatalf("SetStage returned error: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	m = pool.GetMetrics()
+	stop()
+	if m.TargetRPS != 50 {
+		t.Errorf("Expected the set rate to hold against the ramp, got %.0f RPS", m.TargetRPS)
+	}
+	if m.Stage != "ramp-up step 6" {
+		t.Errorf("Expected the ramp to move on to step 6, got %s", m.Stage)
+	}
+}
+
+func TestPool_PauseClosedModel(t *testing.T) {
+	cfg := &config.Config{
+		Endpoints: []config.Endpoint{{URL: newTestServer(t), Method: "GET"}},
+		Model:     "closed",
+		ThinkTime: config.ThinkTime{Distribution: "fixed", Mean: 10 * time.Millisecond},
+	}
+	pool := NewPool(2, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Start(ctx)
+
+	if err := pool.SetRate(10); err == nil {
+		t.Error("Expected setting a rate in the closed model to fail")
+	}
+	pool.Pause()
+	time.Sleep(50 * time.Millisecond)
+	before := pool.GetMetrics().TotalRequests
+	time.Sleep(200 * time.Millisecond)
+	if after := pool.GetMetrics().TotalRequests; after != before {
+		t.Errorf("Expected virtual users to wait while paused, went from %d to %d requests", before, after)
+	}
+	pool.Resume()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	pool.Stop()
+	if pool.GetMetrics().TotalRequests == before {
+		t.Error("Expected virtual users to continue after resume")
+	}
+}
@@ -6,63 +6,118 @@ import (
 	"time"
 )
 
-// RateLimiter controls the rate of requests using a token bucket algorithm
+// RateLimiter paces request dispatch at a target rate. It is the single owner
+// of the target rate: UpdateRate takes effect immediately, including for a
+// caller that is already blocked in Wait. The spacing between requests is drawn
+// from an arrival process, which is independent of how long requests take.
 type RateLimiter struct {
-	rate      float64
-	interval  time.Duration
-	lastCheck time.Time
-	tokens    float64
-	mu        sync.Mutex
+	rate    float64
+	arrival Arrival
+	gap     float64   // spacing before the next request, in mean intervals
+	last    time.Time // time the previous request was scheduled for
+	next    time.Time // time the next request is scheduled for
+	changed chan struct{}
+	mu      sync.Mutex
 }
 
-// NewRateLimiter creates a new rate limiter
+// NewRateLimiter creates a new rate limiter with evenly spaced requests
 func NewRateLimiter(rps int) *RateLimiter {
 	return &RateLimiter{
-		rate:      float64(rps),
-		interval:  time.Second / time.Duration(rps),
-		lastCheck: time.Now(),
-		tokens:    float64(rps),
+		rate:    float64(rps),
+		arrival: constantArrival{},
+		changed: make(chan struct{}),
 	}
 }
 
-// Wait blocks until the next request can be made
-func (r *RateLimiter) Wait(ctx context.Context) error {
+// SetArrival sets the arrival process used to space subsequent requests
+func (r *RateLimiter) SetArrival(arrival Arrival) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.arrival = arrival
+}
 
-	now := time.Now()
-	elapsed := now.Sub(r.lastCheck).Seconds()
-	r.lastCheck = now
+// Wait blocks until the next request is due and returns the time it was
+// scheduled for. Requests are scheduled on a fixed timeline, so a caller that
+// falls behind is let through immediately until it has caught up, and the
+// returned time shows how late it is. A rate of zero blocks until the rate is
+// raised.
+func (r *RateLimiter) Wait(ctx context.Context) (time.Time, error) {
+	var err error
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		if r.rate > 0 {
+			if r.next.IsZero() {
+				r.next = now
+			}
+			if !r.next.After(now) {
+				scheduled := r.next
+				r.last = scheduled
+				r.gap = r.arrival.Next()
+				r.next = scheduled.Add(r.interval())
+				r.mu.Unlock()
+				return scheduled, nil
+			}
+		}
+		wait := r.next.Sub(now)
+		paused := r.rate <= 0
+		changed := r.changed
+		r.mu.Unlock()
 
-	// Add new tokens based on elapsed time
-	r.tokens += elapsed * r.rate
-	if r.tokens > r.rate {
-		r.tokens = r.rate
-	}
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !paused {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
 
-	if r.tokens < 1 {
-		// Calculate wait time needed for 1 token
-		waitTime := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(waitTime):
-			r.tokens = 1
+			err = ctx.Err()
+		case <-changed:
+		case <-due:
 		}
-	}
 
-	r.tokens--
-	return nil
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
 }
 
-// UpdateRate updates the rate limiter's rate
+// UpdateRate updates the rate limiter's rate. The next request is rescheduled
+// from the previous one at the new rate, but never into the past, so raising
+// the rate does not release a burst.
 func (r *RateLimiter) UpdateRate(rps int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if float64(rps) == r.rate {
+		return
+	}
 	r.rate = float64(rps)
-	r.interval = time.Second / time.Duration(rps)
-	if r.tokens > float64(rps) {
-		r.tokens = float64(rps)
+
+	if !r.last.IsZero() && r.rate > 0 {
+		r.next = r.last.Add(r.interval())
+		if now := time.Now(); r.next.Before(now) {
+			r.next = now
+		}
 	}
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Rate returns the current target rate in requests per second
+func (r *RateLimiter) Rate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rate
+}
+
+// interval returns the time until the next request at the current rate
+func (r *RateLimiter) interval() time.Duration {
+	return time.Duration(r.gap * float64(time.Second) / r.rate)
 }
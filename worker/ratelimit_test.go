@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Pacing(t *testing.T) {
+	limiter := NewRateLimiter(200)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	permits := 0
+	for time.Since(start) < 500*time.Millisecond {
+		if _, err := limiter.Wait(ctx); err != nil {
+			t.Fatalf("Wait returned error: %v", err)
+		}
+		permits++
+	}
+
+	// 200 RPS for 500ms is 100 permits, plus the one issued at the start
+	if permits < 95 || permits > 106 {
+		t.Errorf("Expected around 100 permits, got %d", permits)
+	}
+}
+
+func TestRateLimiter_UpdateRateWakesWaiter(t *testing.T) {
+	limiter := NewRateLimiter(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The first permit is immediate, the second is due a second later
+	if _, err := limiter.Wait(ctx); err != nil {
+		t.Fatalf("Wait returned error: %v", err)
+	}
+
+	done := make(chan time.Time)
+	go func() {
+		limiter.Wait(ctx)
+		done <- time.Now()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	raised := time.Now()
+	limiter.UpdateRate(100)
+
+	select {
+	case released := <-done:
+		if d := released.Sub(raised); d > 100*time.Millisecond {
+			t.Errorf("Expected waiter to be released right after the rate change, took %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Waiter was not released after raising the rate")
+	}
+}
+
+func TestRateLimiter_NoBurstAfterRaise(t *testing.T) {
+	limiter := NewRateLimiter(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	limiter.Wait(ctx)
+	time.Sleep(400 * time.Millisecond)
+	limiter.UpdateRate(10)
+
+	// The raised rate allows one request immediately and then one per 100ms,
+	// not a catch-up burst for the time spent at the lower rate.
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		limiter.Wait(ctx)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected paced permits after raising the rate, got 4 in %v", elapsed)
+	}
+}
+
+func TestRateLimiter_ZeroRatePauses(t *testing.T) {
+	limiter := NewRateLimiter(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, err := limiter.Wait(ctx); err == nil {
+		t.Error("Expected Wait to block until the context expired at zero rate")
+	}
+}
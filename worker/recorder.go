@@ -0,0 +1,291 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+	"protobuf/tracing"
+)
+
+// latencyPrecision is the number of significant digits latency histograms keep
+const latencyPrecision = 3
+
+// maxSlowestTraced is how many of the slowest sampled requests are reported
+const maxSlowestTraced = 10
+
+// result is the outcome of a single request
+type result struct {
+	endpoint int // index into the configured endpoints
+	start    time.Time
+	status   int // HTTP status code, 0 if no response was received
+	success  bool
+	class    string              // error class of a failed request
+	message  string              // error message of a failed request
+	span     tracing.SpanContext // trace context, sampled if the request was traced
+	phases   *phaseTimings       // nil unless phase timings are enabled
+	bytesOut int64               // request size, only measured for the request log
+	bytesIn  int64               // response size, only measured for the request log
+	warmup   bool                // scheduled during warmup
+}
+
+// fail marks the result as failed with the given class and message
+func (r *result) fail(class, message string) {
+	r.success = false
+	r.class = class
+	r.message = message
+}
+
+// recorder collects the results of the requests sent by a single worker,
+// broken down by endpoint. Workers record into their own recorder, so
+// recording never contends on a shared lock; the pool merges every recorder
+// when metrics are read.
+type recorder struct {
+	worker    int // index of the worker in the pool
+	endpoints []*endpointRecorder
+	warmup    []*endpointRecorder               // results of warmup requests, nil without a warmup
+	interval  atomic.Pointer[metrics.Histogram] // response times of the current time series interval
+}
+
+// endpointRecorder holds one worker's results for a single endpoint
+type endpointRecorder struct {
+	total        atomic.Int64
+	successful   atomic.Int64
+	failed       atomic.Int64
+	late         atomic.Int64
+	serviceTime  *metrics.Histogram
+	responseTime *metrics.Histogram
+	mu           sync.Mutex // guards errors and statusCodes against concurrent reads
+	errors       map[string]*errorRecord
+	statusCodes  map[int]int64
+	slowest      []config.TracedRequest // slowest sampled requests, guarded by mu
+	phases       *phaseHistograms       // nil unless phase timings are enabled
+}
+
+// newRecorder creates an empty recorder for the given number of endpoints,
+// with phase histograms if phase timings are enabled
+func newRecorder(endpoints int, phases bool) *recorder {
+	r := &recorder{endpoints: newEndpointRecorders(endpoints, phases)}
+	r.interval.Store(metrics.NewHistogram(latencyPrecision))
+	return r
+}
+
+// newEndpointRecorders creates an empty recorder per endpoint
+func newEndpointRecorders(endpoints int, phases bool) []*endpointRecorder {
+	recorders := make([]*endpointRecorder, endpoints)
+	for i := range recorders {
+		recorders[i] = &endpointRecorder{
+			serviceTime:  metrics.NewHistogram(latencyPrecision),
+			responseTime: metrics.NewHistogram(latencyPrecision),
+			errors:       make(map[string]*errorRecord),
+			statusCodes:  make(map[int]int64),
+		}
+		if phases {
+			recorders[i].phases = newPhaseHistograms()
+		}
+	}
+	return recorders
+}
+
+// results returns the endpoint recorders of warmup or of measured requests
+func (r *recorder) results(warmup bool) []*endpointRecorder {
+	if warmup {
+		return r.warmup
+	}
+	return r.endpoints
+}
+
+// record adds the result of a single request
+func (r *recorder) record(res result, serviceTime, responseTime time.Duration, late bool) {
+	e := r.endpoints[res.endpoint]
+	if res.warmup && r.warmup != nil {
+		e = r.warmup[res.endpoint]
+	}
+	e.total.Add(1)
+	if res.success {
+		e.successful.Add(1)
+	} else {
+		e.failed.Add(1)
+	}
+
+	if !res.success || res.status != 0 || res.span.Sampled {
+		e.mu.Lock()
+		if res.status != 0 {
+			e.statusCodes[res.status]++
+		}
+		if !res.success {
+			record := e.errors[res.class]
+			if record == nil {
+				record = &errorRecord{}
+				e.errors[res.class] = record
+			}
+			record.add(1, res.message)
+		}
+		if res.span.Sampled {
+			e.slowest = keepSlowest(e.slowest, config.TracedRequest{
+				TraceID:      res.span.TraceIDString(),
+				Start:        res.start,
+				ResponseTime: responseTime,
+				Status:       res.status,
+				Error:        res.class,
+			})
+		}
+		e.mu.Unlock()
+	}
+	if late {
+		e.late.Add(1)
+	}
+	e.serviceTime.Record(serviceTime)
+	e.responseTime.Record(responseTime)
+	if res.phases != nil && e.phases != nil {
+		e.phases.record(res.phases)
+	}
+	r.interval.Load().Record(responseTime)
+}
+
+// snapshot merges recorders into run-wide and per-endpoint metrics, of either
+// the warmup or the measured requests. Throughput is calculated over elapsed.
+func snapshot(m *config.Metrics, recorders []*recorder, endpoints []config.Endpoint, elapsed time.Duration, warmup bool) {
+	serviceTime := metrics.NewHistogram(latencyPrecision)
+	responseTime := metrics.NewHistogram(latencyPrecision)
+	errs := make(map[string]*errorRecord)
+	m.StatusCodes = make(map[int]int64)
+	var slowest []config.TracedRequest
+	var phases *phaseHistograms
+
+	for i, endpoint := range endpoints {
+		em := config.EndpointMetrics{
+			Name:        endpoint.DisplayName(),
+			StatusCodes: make(map[int]int64),
+		}
+		epErrs := make(map[string]*errorRecord)
+		epService := metrics.NewHistogram(latencyPrecision)
+		epResponse := metrics.NewHistogram(latencyPrecision)
+		var epPhases *phaseHistograms
+
+		for _, rec := range recorders {
+			e := rec.results(warmup)[i]
+			em.TotalRequests += e.total.Load()
+			em.SuccessfulRequests += e.successful.Load()
+			em.FailedRequests += e.failed.Load()
+			m.LateRequests += e.late.Load()
+			epService.Merge(e.serviceTime)
+			epResponse.Merge(e.responseTime)
+			if e.phases != nil {
+				if epPhases == nil {
+					epPhases = newPhaseHistograms()
+				}
+				epPhases.merge(e.phases)
+			}
+
+			e.mu.Lock()
+			for class, record := range e.errors {
+				mergeErrorRecord(epErrs, class, record)
+				mergeErrorRecord(errs, class, record)
+			}
+			for status, count := range e.statusCodes {
+				em.StatusCodes[status] += count
+				m.StatusCodes[status] += count
+			}
+			for _, traced := range e.slowest {
+				traced.Endpoint = em.Name
+				slowest = keepSlowest(slowest, traced)
+			}
+			e.mu.Unlock()
+		}
+		em.Errors = errorStats(epErrs)
+
+		if elapsed > 0 {
+			em.RPS = float64(em.TotalRequests) / elapsed.Seconds()
+		}
+		em.LatencyStats = latencyStats(epService)
+		em.ResponseTimeStats = latencyStats(epResponse)
+		if epPhases != nil {
+			em.Phases = epPhases.stats()
+			if phases == nil {
+				phases = newPhaseHistograms()
+			}
+			phases.merge(epPhases)
+		}
+		m.Endpoints = append(m.Endpoints, em)
+
+		m.TotalRequests += em.TotalRequests
+		m.SuccessfulRequests += em.SuccessfulRequests
+		m.FailedRequests += em.FailedRequests
+		serviceTime.Merge(epService)
+		responseTime.Merge(epResponse)
+	}
+
+	if elapsed > 0 {
+		m.AchievedRPS = float64(m.TotalRequests) / elapsed.Seconds()
+	}
+	m.Elapsed = elapsed
+	m.Errors = errorStats(errs)
+	sort.Slice(slowest, func(i, j int) bool {
+		return slowest[i].ResponseTime > slowest[j].ResponseTime
+	})
+	m.SlowestTraced = slowest
+	m.LatencyStats = latencyStats(serviceTime)
+	m.ResponseTimeStats = latencyStats(responseTime)
+	if phases != nil {
+		m.Phases = phases.stats()
+	}
+}
+
+// keepSlowest adds a traced request to list if it is among the slowest seen
+func keepSlowest(list []config.TracedRequest, r config.TracedRequest) []config.TracedRequest {
+	if len(list) < maxSlowestTraced {
+		return append(list, r)
+	}
+	fastest := 0
+	for i := range list {
+		if list[i].ResponseTime < list[fastest].ResponseTime {
+			fastest = i
+		}
+	}
+	if r.ResponseTime > list[fastest].ResponseTime {
+		list[fastest] = r
+	}
+	return list
+}
+
+// mergeErrorRecord adds record to the class in errs
+func mergeErrorRecord(errs map[string]*errorRecord, class string, record *errorRecord) {
+	merged := errs[class]
+	if merged == nil {
+		merged = &errorRecord{}
+		errs[class] = merged
+	}
+	merged.add(record.count, record.samples...)
+}
+
+// errorStats converts error records to their reported form
+func errorStats(errs map[string]*errorRecord) map[string]config.ErrorStats {
+	stats := make(map[string]config.ErrorStats, len(errs))
+	for class, record := range errs {
+		stats[class] = config.ErrorStats{
+			Count:   record.count,
+			Samples: append([]string(nil), record.samples...),
+		}
+	}
+	return stats
+}
+
+// latencyStats derives the latency statistics from a histogram
+func latencyStats(h *metrics.Histogram) config.LatencyStats {
+	return config.LatencyStats{
+		Min:       h.Min(),
+		Max:       h.Max(),
+		Mean:      h.Mean(),
+		P50:       h.Quantile(0.5),
+		P95:       h.Quantile(0.95),
+		P99:       h.Quantile(0.99),
+		P999:      h.Quantile(0.999),
+		P9999:     h.Quantile(0.9999),
+		P99999:    h.Quantile(0.99999),
+		Histogram: h,
+	}
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"io"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+	"protobuf/requestlog"
+)
+
+// Replay rebuilds the metrics of a run from its request log. Everything the
+// log records per request is rebuilt exactly: counts, latencies, error
+// classes, status codes, per-endpoint breakdowns, the time series and the
+// split between warmup and measured requests. What it does not record, such
+// as dropped and cancelled requests, target rates, load stages after the
+// warmup, annotations and error messages, is left empty. The run is timed by
+// the log's footer; a log without one, such as that of a run cut short, is
+// timed up to its last response.
+func Replay(log *requestlog.Reader) (*config.Metrics, error) {
+	header := log.Header
+	lateThreshold := header.LateThreshold
+	if lateThreshold <= 0 {
+		lateThreshold = defaultLateThreshold
+	}
+	interval := header.MetricsInterval
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+
+	endpoints := make([]config.Endpoint, len(header.Endpoints))
+	for i, name := range header.Endpoints {
+		endpoints[i] = config.Endpoint{Name: name}
+	}
+
+	// The last interval of a run stretches to when the pool stopped, so it
+	// also takes requests that finished just after the planned duration
+	last := -1
+	if header.Duration > 0 {
+		last = int((header.Warmup+header.Duration+interval-1)/interval) - 1
+	}
+
+	warmupEnd := header.Started.Add(header.Warmup)
+	rec := newRecorder(len(endpoints), false)
+	if header.Warmup > 0 {
+		rec.warmup = newEndpointRecorders(len(endpoints), false)
+	}
+	var series []replayInterval
+	workers := make(map[int]bool)
+	var end time.Time
+
+	for {
+		r, err := log.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res := result{
+			endpoint: r.Endpoint,
+			start:    r.Start,
+			status:   r.Status,
+			success:  r.Error == "",
+			class:    r.Error,
+			warmup:   r.Intended.Before(warmupEnd),
+		}
+		rec.record(res, r.Latency, r.ResponseTime(), r.Start.Sub(r.Intended) > lateThreshold)
+		workers[r.Worker] = true
+		if r.End().After(end) {
+			end = r.End()
+		}
+
+		// Requests count towards the interval they finished in, as they
+		// do during a run
+		i := int(r.End().Sub(header.Started) / interval)
+		if i < 0 {
+			i = 0
+		}
+		if last >= 0 && i > last {
+			i = last
+		}
+		for len(series) <= i {
+			series = append(series, replayInterval{responseTime: metrics.NewHistogram(latencyPrecision)})
+		}
+		series[i].requests++
+		if r.Error != "" {
+			series[i].failed++
+		}
+		series[i].responseTime.Record(r.ResponseTime())
+	}
+
+	var elapsed, warmupElapsed time.Duration
+	if footer := log.Footer; footer != nil {
+		elapsed = footer.Stopped.Sub(footer.Started)
+	} else if !end.IsZero() {
+		elapsed = end.Sub(header.Started)
+	}
+	m := &config.Metrics{PeakWorkers: int64(len(workers))}
+	if header.Warmup > 0 {
+		warmupElapsed = header.Warmup
+		if elapsed < warmupElapsed {
+			warmupElapsed = elapsed
+		}
+		m.Warmup = &config.Metrics{}
+		snapshot(m.Warmup, []*recorder{rec}, endpoints, warmupElapsed, true)
+	}
+	snapshot(m, []*recorder{rec}, endpoints, elapsed-warmupElapsed, false)
+
+	for i, s := range series {
+		start := time.Duration(i) * interval
+		duration := interval
+		if i == len(series)-1 {
+			duration = elapsed - start
+		}
+		im := config.IntervalMetrics{
+			Start:    start,
+			Duration: duration,
+			Requests: s.requests,
+			Failed:   s.failed,
+			P50:      s.responseTime.Quantile(0.5),
+			P95:      s.responseTime.Quantile(0.95),
+			P99:      s.responseTime.Quantile(0.99),
+			Max:      s.responseTime.Max(),
+			Partial:  duration < interval,
+		}
+		if start < header.Warmup {
+			im.Stage = config.WarmupStage
+		}
+		if duration > 0 {
+			im.AchievedRPS = float64(s.requests) / duration.Seconds()
+		}
+		if s.requests > 0 {
+			im.ErrorRate = float64(s.failed) / float64(s.requests)
+		}
+		m.TimeSeries = append(m.TimeSeries, im)
+	}
+	m.CurrentRPS = currentRPS(m.TimeSeries)
+	return m, nil
+}
+
+// replayInterval accumulates the requests that finished in one interval
+type replayInterval struct {
+	requests     int64
+	failed       int64
+	responseTime *metrics.Histogram
+}
@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+
+	"protobuf/config"
+)
+
+// thinkTimer draws the pause a virtual user takes between requests. Each
+// virtual user owns one, so it needs no locking.
+type thinkTimer struct {
+	cfg config.ThinkTime
+	rng *rand.Rand
+}
+
+// newThinkTimer creates a think timer for the given distribution
+func newThinkTimer(cfg config.ThinkTime, seed int64) *thinkTimer {
+	return &thinkTimer{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Next returns the next think time
+func (t *thinkTimer) Next() time.Duration {
+	return t.cfg.Draw(t.rng)
+}
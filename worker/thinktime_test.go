@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"protobuf/config"
+)
+
+func TestThinkTimer_Distributions(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ThinkTime
+		mean time.Duration
+		min  time.Duration
+		max  time.Duration
+	}{
+		{"fixed", config.ThinkTime{Mean: time.Second}, time.Second, time.Second, time.Second},
+		{"uniform", config.ThinkTime{Distribution: "uniform", Min: time.Second, Max: 3 * time.Second}, 2 * time.Second, time.Second, 3 * time.Second},
+		{"exponential", config.ThinkTime{Distribution: "exponential", Mean: time.Second}, time.Second, 0, time.Duration(math.MaxInt64)},
+		{"normal", config.ThinkTime{Distribution: "normal", Mean: time.Second, StdDev: 100 * time.Millisecond}, time.Second, 0, time.Duration(math.MaxInt64)},
+	}
+
+	const samples = 100000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			think := newThinkTimer(tt.cfg, 1)
+
+			var sum time.Duration
+			for i := 0; i < samples; i++ {
+				d := think.Next()
+				if d < tt.min || d > tt.max {
+					t.Fatalf("Think time %v outside [%v, %v]", d, tt.min, tt.max)
+				}
+				sum += d
+			}
+
+			mean := sum / samples
+			if diff := mean - tt.mean; diff < -tt.mean/100 || diff > tt.mean/100 {
+				t.Errorf("Expected mean think time %v, got %v", tt.mean, mean)
+			}
+		})
+	}
+}
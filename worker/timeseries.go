@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"protobuf/config"
+	"protobuf/metrics"
+)
+
+// defaultMetricsInterval is the width of a time series interval, when the
+// config does not set metrics_interval
+const defaultMetricsInterval = time.Second
+
+// intervalState is what the sampler carries from one interval to the next.
+// Counts are cumulative, so each interval is the difference from the last.
+type intervalState struct {
+	start   time.Time
+	total   int64
+	failed  int64
+	dropped int64
+}
+
+// sampleIntervals closes a time series interval every metrics interval until
+// the test ends. Stop closes the final, partial interval.
+func (p *Pool) sampleIntervals(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.metricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopChan:
+			return
+		case now := <-ticker.C:
+			p.closeInterval(now, false)
+		}
+	}
+}
+
+// closeInterval appends the interval ending at end to the time series. Each
+// worker's interval histogram is swapped for an empty one, so a response
+// recorded while the swap is in progress may miss the time series; request
+// counts and run-wide latencies are always exact. A partial interval is the
+// one the end of the test cuts short.
+func (p *Pool) closeInterval(end time.Time, partial bool) {
+	p.mu.Lock()
+	recorders := append([]*recorder(nil), p.recorders...)
+	prev := p.interval
+	stage := p.stage
+	duration := end.Sub(prev.start)
+	// Stages change on the same ticks intervals close on, so label the
+	// interval with the stage it spent most of its time in
+	if p.prevStage != "" && p.stageSince.After(prev.start.Add(duration/2)) {
+		stage = p.prevStage
+	}
+	p.mu.Unlock()
+
+	if duration <= 0 {
+		return
+	}
+
+	responseTime := metrics.NewHistogram(latencyPrecision)
+	var total, failed int64
+	for _, rec := range recorders {
+		responseTime.Merge(rec.interval.Swap(metrics.NewHistogram(latencyPrecision)))
+		for _, set := range [][]*endpointRecorder{rec.endpoints, rec.warmup} {
+			for _, e := range set {
+				total += e.total.Load()
+				failed += e.failed.Load()
+			}
+		}
+	}
+	dropped := p.dropped.all.Load()
+
+	im := config.IntervalMetrics{
+		Start:       prev.start.Sub(p.started),
+		Duration:    duration,
+		Stage:       stage,
+		Requests:    total - prev.total,
+		Failed:      failed - prev.failed,
+		Dropped:     dropped - prev.dropped,
+		AchievedRPS: float64(total-prev.total) / duration.Seconds(),
+		P50:         responseTime.Quantile(0.5),
+		P95:         responseTime.Quantile(0.95),
+		P99:         responseTime.Quantile(0.99),
+		Max:         responseTime.Max(),
+		Partial:     partial,
+	}
+	if im.Requests > 0 {
+		im.ErrorRate = float64(im.Failed) / float64(im.Requests)
+	}
+	if p.config.Model != "closed" {
+		im.TargetRPS = p.rateLimiter.Rate()
+	}
+
+	p.mu.Lock()
+	// Annotations made after the tick the interval closes on belong to the next
+	n := 0
+	for n < len(p.annotations) && p.annotations[n].At <= im.Start+duration {
+		n++
+	}
+	im.Annotations = p.annotations[:n:n]
+	p.annotations = p.annotations[n:]
+	p.series = append(p.series, im)
+	p.interval = intervalState{start: end, total: total, failed: failed, dropped: dropped}
+	p.mu.Unlock()
+}
+
+// currentRPS returns the rate achieved over the last full interval. The
+// partial interval at the end of a test is too short to be representative,
+// unless the test was shorter than one interval and it is all there is.
+func currentRPS(series []config.IntervalMetrics) float64 {
+	for i := len(series) - 1; i >= 0; i-- {
+		if !series[i].Partial {
+			return series[i].AchievedRPS
+		}
+	}
+	if len(series) > 0 {
+		return series[len(series)-1].AchievedRPS
+	}
+	return 0
+}